@@ -18,8 +18,6 @@ import (
 	"github.com/danbai225/gpp/backend/data"
 	"github.com/danbai225/gpp/backend/errors"
 	"github.com/danbai225/gpp/systray"
-	box "github.com/sagernet/sing-box"
-	netutils "github.com/shirou/gopsutil/v3/net"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -29,8 +27,12 @@ type App struct {
 	conf     *config.Config
 	gamePeer *config.Peer
 	httpPeer *config.Peer
-	box      *box.Box
-	lock     sync.Mutex
+	box      *client.Box
+	// dnsLog 最近一次加速的 DNS 日志，停止后保留
+	dnsLog *client.DNSLog
+	// loadErr 启动时加载配置失败的原因，此时内存中为空配置，不能写回配置文件
+	loadErr error
+	lock    sync.Mutex
 }
 
 // NewApp creates a new App application struct
@@ -135,6 +137,7 @@ func (a *App) startup(ctx context.Context) {
 	go systray.Run(a.systemTray, func() {})
 	loadConfig, err := config.LoadConfig()
 	if err != nil {
+		a.loadErr = err
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
 			Type:    runtime.WarningDialog,
 			Title:   "配置加载错误",
@@ -146,25 +149,30 @@ func (a *App) startup(ctx context.Context) {
 	if len(a.conf.PeerList) > 0 {
 		if a.conf.GamePeer == "" {
 			a.conf.GamePeer = a.conf.PeerList[0].Name
-		} else {
-			for _, peer := range a.conf.PeerList {
-				if peer.Name == a.conf.GamePeer {
-					a.gamePeer = peer
-				}
-			}
 		}
 		if a.conf.HTTPPeer == "" {
 			a.conf.HTTPPeer = a.conf.PeerList[0].Name
-		} else {
-			for _, peer := range a.conf.PeerList {
-				if peer.Name == a.conf.HTTPPeer {
-					a.httpPeer = peer
-				}
+		}
+		for _, peer := range a.conf.PeerList {
+			if peer.Name == a.conf.GamePeer {
+				a.gamePeer = peer
+			}
+			if peer.Name == a.conf.HTTPPeer {
+				a.httpPeer = peer
 			}
 		}
 	}
 	go a.testPing()
 }
+
+// saveConfig 保存配置，启动时加载失败则拒绝保存，避免空配置覆盖用户的节点列表
+func (a *App) saveConfig() error {
+	if a.loadErr != nil {
+		return fmt.Errorf("config not loaded, refusing to overwrite: %w", a.loadErr)
+	}
+	return config.SaveConfig(a.conf)
+}
+
 func (a *App) PingAll() {
 	a.lock.Lock()
 	if a.box != nil {
@@ -190,7 +198,12 @@ func (a *App) PingAll() {
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				job.peer.Ping = pingPort(job.peer.Addr, job.peer.Port)
+				// 使用前置节点时测试整条代理链的延迟
+				if job.peer.Detour != "" {
+					job.peer.Ping, _ = client.PingChain(job.peer, a.conf.PeerList)
+				} else {
+					job.peer.Ping = pingPort(job.peer.Addr, job.peer.Port)
+				}
 				results <- struct{}{}
 			}
 		}()
@@ -216,16 +229,23 @@ func (a *App) Status() *data.Status {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 	status := data.Status{
-		Running:  a.box != nil,
-		GamePeer: a.gamePeer,
-		HttpPeer: a.httpPeer,
+		Running:     a.box != nil,
+		GamePeer:    a.gamePeer,
+		HttpPeer:    a.httpPeer,
+		InboundMode: a.conf.InboundMode,
+		Mode:        a.conf.Mode,
 	}
-
-	counters, _ := netutils.IOCounters(true)
-	for _, counter := range counters {
-		if counter.Name == "utun225" {
-			status.Up = counter.BytesSent
-			status.Down = counter.BytesRecv
+	if status.InboundMode != config.InboundModeProxy {
+		status.InboundMode = config.InboundModeTun
+	}
+	if a.box != nil {
+		status.Mode = a.box.Mode()
+		status.ActivePeer = a.box.ActivePeer()
+		status.KillSwitch = a.box.KillSwitchActive()
+		status.Traffic = a.box.Tracker.Traffic()
+		for _, traffic := range status.Traffic {
+			status.Up += traffic.Up
+			status.Down += traffic.Down
 		}
 	}
 	return &status
@@ -279,7 +299,7 @@ func (a *App) Add(token string) string {
 		}
 		a.conf.PeerList = append(a.conf.PeerList, peer)
 	}
-	err := config.SaveConfig(a.conf)
+	err := a.saveConfig()
 	if err != nil {
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
 			Type:    runtime.ErrorDialog,
@@ -318,7 +338,7 @@ func (a *App) importSingBoxConfig(configJson string) string {
 	successCount, skipCount := a.checkAndMergePeers(peers)
 	
 	// 保存配置
-	err = config.SaveConfig(a.conf)
+	err = a.saveConfig()
 	if err != nil {
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
 			Type:    runtime.ErrorDialog,
@@ -380,28 +400,182 @@ func (a *App) Del(Name string) string {
 			break
 		}
 	}
-	err := config.SaveConfig(a.conf)
+	err := a.saveConfig()
 	if err != nil {
 		return err.Error()
 	}
 	return "ok"
 }
+// SetPeer 设置游戏和 HTTP 节点，加速中立即切换，无需重建 TUN
 func (a *App) SetPeer(game, http string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	gamePeer, httpPeer := a.gamePeer, a.httpPeer
 	for _, peer := range a.conf.PeerList {
 		if peer.Name == game {
-			a.gamePeer = peer
-			a.conf.GamePeer = peer.Name
+			gamePeer = peer
 			break
 		}
 	}
 	for _, peer := range a.conf.PeerList {
 		if peer.Name == http {
-			a.httpPeer = peer
-			a.conf.HTTPPeer = peer.Name
+			httpPeer = peer
 			break
 		}
 	}
-	err := config.SaveConfig(a.conf)
+	// 启用故障转移时游戏流量由节点组选择节点，不能手动切换游戏节点，HTTP 节点仍可切换
+	if a.conf.Failover != nil && a.gamePeer != nil && gamePeer != a.gamePeer {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "切换节点失败",
+			Message: "已启用故障转移，游戏节点由节点组自动选择",
+		})
+		return "failover enabled, game peer is selected by the failover group"
+	}
+	// 先切换运行中的选择器，成功后再记录新节点，失败时恢复原来的选择
+	if a.box != nil {
+		if err := a.selectPeers(gamePeer, httpPeer); err != nil {
+			_ = a.selectPeers(a.gamePeer, a.httpPeer)
+			_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+				Type:    runtime.ErrorDialog,
+				Title:   "切换节点失败",
+				Message: err.Error(),
+			})
+			return err.Error()
+		}
+	}
+	a.gamePeer, a.httpPeer = gamePeer, httpPeer
+	if gamePeer != nil {
+		a.conf.GamePeer = gamePeer.Name
+	}
+	if httpPeer != nil {
+		a.conf.HTTPPeer = httpPeer.Name
+	}
+	err := a.saveConfig()
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "保存错误",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return "ok"
+}
+
+// selectPeers 将运行中的选择器切换到指定的游戏和 HTTP 节点
+func (a *App) selectPeers(gamePeer, httpPeer *config.Peer) error {
+	if gamePeer == nil {
+		return nil
+	}
+	if httpPeer == nil {
+		httpPeer = gamePeer
+	}
+	// 启用故障转移时游戏流量由节点组选择节点
+	if a.conf.Failover == nil {
+		if err := a.box.SelectPeer("proxy", gamePeer); err != nil {
+			return err
+		}
+	}
+	// 启用负载均衡时 HTTP 流量由均衡组选择节点
+	if a.conf.LoadBalance != nil {
+		return nil
+	}
+	return a.box.SelectPeer("http", httpPeer)
+}
+
+// SetInboundMode 设置入站模式，下次启动加速时生效
+func (a *App) SetInboundMode(mode string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box != nil {
+		return "running"
+	}
+	switch mode {
+	case config.InboundModeTun, config.InboundModeProxy:
+	default:
+		return fmt.Sprintf("unknown inbound mode: %s", mode)
+	}
+	a.conf.InboundMode = mode
+	err := a.saveConfig()
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "保存错误",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return "ok"
+}
+
+// SetDNS 设置代理 DNS 和直连 DNS，检查格式和可用性后保存，下次启动加速时生效
+func (a *App) SetDNS(proxyDNS, localDNS string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box != nil {
+		return "running"
+	}
+	conf := *a.conf
+	conf.ProxyDNS = proxyDNS
+	conf.LocalDNS = localDNS
+	validator := config.NewConfigValidator()
+	// 加载配置时不检查 DNS 地址，设置时需先检查
+	err := validator.ValidateDNSAddresses(&conf)
+	if err == nil {
+		err = validator.Validate(&conf)
+	}
+	if err == nil {
+		// 代理 DNS 经游戏节点查询
+		err = client.CheckDNS(conf.ProxyDNS, a.gamePeer, conf.PeerList)
+		if err != nil {
+			err = fmt.Errorf("proxy_dns unreachable: %w", err)
+		}
+	}
+	if err == nil {
+		err = client.CheckDNS(conf.LocalDNS, nil, nil)
+		if err != nil {
+			err = fmt.Errorf("local_dns unreachable: %w", err)
+		}
+	}
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "DNS 设置错误",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	a.conf.ProxyDNS = conf.ProxyDNS
+	a.conf.LocalDNS = conf.LocalDNS
+	err = a.saveConfig()
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "保存错误",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return "ok"
+}
+
+// SetMode 切换路由模式，运行中立即生效
+func (a *App) SetMode(mode string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch mode {
+	case config.ModeRule, config.ModeGlobal, config.ModeDirect:
+	default:
+		return fmt.Sprintf("unknown mode: %s", mode)
+	}
+	if a.box != nil {
+		if err := a.box.SetMode(mode); err != nil {
+			return err.Error()
+		}
+	}
+	a.conf.Mode = mode
+	err := a.saveConfig()
 	if err != nil {
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
 			Type:    runtime.ErrorDialog,
@@ -413,6 +587,178 @@ func (a *App) SetPeer(game, http string) string {
 	return "ok"
 }
 
+// ListProfiles 返回全部可用的游戏配置
+func (a *App) ListProfiles() []*config.GameProfile {
+	profiles, err := config.NewProfileStore().List()
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "读取游戏配置失败",
+			Message: err.Error(),
+		})
+		return nil
+	}
+	return profiles
+}
+
+// ActiveProfiles 返回启用的游戏配置名称
+func (a *App) ActiveProfiles() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.conf.Profiles == nil {
+		return config.DefaultProfiles()
+	}
+	return a.conf.Profiles
+}
+
+// ImportProfile 选择游戏配置文件并导入到配置目录
+func (a *App) ImportProfile() string {
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "导入游戏配置",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "游戏配置 (*.json)", Pattern: "*.json"},
+		},
+	})
+	if err != nil {
+		return err.Error()
+	}
+	if path == "" {
+		return "cancel"
+	}
+	profile, err := config.NewProfileStore().Import(path)
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "导入失败",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return profile.Name
+}
+
+// SetProfiles 设置启用的游戏配置，下次启动加速时生效
+func (a *App) SetProfiles(names []string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box != nil {
+		return "running"
+	}
+	if names == nil {
+		names = []string{}
+	}
+	if _, err := config.NewProfileStore().Load(names); err != nil {
+		return err.Error()
+	}
+	a.conf.Profiles = names
+	err := a.saveConfig()
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "保存错误",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return "ok"
+}
+
+// Connections 返回活动连接、命中的规则和流量
+func (a *App) Connections() []data.Connection {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box == nil {
+		return nil
+	}
+	return a.box.Tracker.Connections()
+}
+
+// CloseConnection 关闭指定的活动连接
+func (a *App) CloseConnection(id string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box == nil {
+		return "not running"
+	}
+	if err := a.box.Tracker.Close(id); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// DNSLog 返回最近一次加速的 DNS 查询记录
+func (a *App) DNSLog() []data.DNSQuery {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.dnsLog == nil {
+		return nil
+	}
+	return a.dnsLog.Queries()
+}
+
+// DNSLeakCheck 返回最近一次加速期间未经代理查询的域名
+func (a *App) DNSLeakCheck() []data.DNSLeak {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.dnsLog == nil {
+		return nil
+	}
+	return a.dnsLog.Leaks()
+}
+
+// ExportDNSLog 导出 DNS 查询记录
+func (a *App) ExportDNSLog() string {
+	a.lock.Lock()
+	dnsLog := a.dnsLog
+	a.lock.Unlock()
+	if dnsLog == nil {
+		return "empty"
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "导出 DNS 日志",
+		DefaultFilename: "dns.json",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "DNS 日志 (*.json)", Pattern: "*.json"},
+		},
+	})
+	if err != nil {
+		return err.Error()
+	}
+	if path == "" {
+		return "cancel"
+	}
+	if err = dnsLog.Export(path); err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "导出失败",
+			Message: err.Error(),
+		})
+		return err.Error()
+	}
+	return "ok"
+}
+
+// Rates 返回各出站的实时速率、峰值和配置的 rate_history 时长内的速率历史
+func (a *App) Rates() *data.Rates {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box == nil {
+		return nil
+	}
+	rates := a.box.Rates()
+	return &rates
+}
+
+// BalanceStats 返回 HTTP 负载均衡各节点的健康状态和流量
+func (a *App) BalanceStats() []data.BalanceMember {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.box == nil {
+		return nil
+	}
+	return a.box.BalanceStats()
+}
+
 // Start 启动加速
 func (a *App) Start() string {
 	a.lock.Lock()
@@ -420,8 +766,16 @@ func (a *App) Start() string {
 	if a.box != nil {
 		return "running"
 	}
-	var err error
-	a.box, err = client.Client(a.gamePeer, a.httpPeer, a.conf.ProxyDNS, a.conf.LocalDNS, a.conf.Rules)
+	profiles, err := config.NewProfileStore().Load(a.conf.Profiles)
+	if err != nil {
+		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
+			Type:    runtime.ErrorDialog,
+			Title:   "加速失败",
+			Message: fmt.Sprintf("加载游戏配置失败: %s", err.Error()),
+		})
+		return err.Error()
+	}
+	a.box, err = client.Client(a.gamePeer, a.httpPeer, a.conf, profiles)
 	if err != nil {
 		// 根据错误类型提供更友好的提示
 		appErr := errors.NewNetworkError("创建代理客户端失败", err)
@@ -430,11 +784,15 @@ func (a *App) Start() string {
 		if strings.Contains(err.Error(), "permission") {
 			appErr = errors.NewPermissionError("权限不足", err).
 				WithUserMessage("需要管理员权限来创建网络接口").
-				WithSuggestion("请以管理员身份运行程序")
+				WithSuggestion("请以管理员身份运行程序，或切换到无需管理员权限的代理模式")
 		} else if strings.Contains(err.Error(), "address already in use") {
 			appErr = errors.NewNetworkError("端口已被占用", err).
 				WithUserMessage("代理端口已被其他程序占用").
 				WithSuggestion("请检查是否有其他VPN或代理程序正在运行")
+		} else if strings.Contains(err.Error(), "proxy_dns") || strings.Contains(err.Error(), "local_dns") {
+			appErr = errors.NewConfigError("DNS 地址无效", err).
+				WithUserMessage("已保存的 DNS 地址不再支持：" + err.Error()).
+				WithSuggestion("请在设置中重新设置代理 DNS 和直连 DNS")
 		}
 
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
@@ -453,7 +811,7 @@ func (a *App) Start() string {
 		if strings.Contains(err.Error(), "TUN") {
 			appErr = errors.NewPermissionError("创建TUN接口失败", err).
 				WithUserMessage("无法创建虚拟网络接口").
-				WithSuggestion("请确保以管理员权限运行，并检查系统是否支持TUN设备")
+				WithSuggestion("请确保以管理员权限运行，并检查系统是否支持TUN设备，或切换到代理模式")
 		}
 
 		_, _ = runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
@@ -464,6 +822,7 @@ func (a *App) Start() string {
 		a.box = nil
 		return appErr.Error()
 	}
+	a.dnsLog = a.box.DNSLog
 	return "ok"
 }
 
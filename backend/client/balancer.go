@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/danbai225/gpp/backend/data"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/adapter/outbound"
+	"github.com/sagernet/sing-box/common/urltest"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/bufio"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json/badoption"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	loadBalanceType = "load_balance"
+	loadBalanceTag  = "balance"
+)
+
+// LoadBalanceOptions 负载均衡出站参数
+type LoadBalanceOptions struct {
+	Outbounds []string           `json:"outbounds"`
+	Strategy  string             `json:"strategy"`
+	URL       string             `json:"url,omitempty"`
+	Interval  badoption.Duration `json:"interval,omitempty"`
+}
+
+func registerBalancer(registry *outbound.Registry) {
+	outbound.Register[LoadBalanceOptions](registry, loadBalanceType, newBalancer)
+}
+
+// balanceOut 生成 HTTP 流量的负载均衡出站
+func balanceOut(balance *config.LoadBalance) option.Outbound {
+	tags := make([]string, len(balance.Peers))
+	for i, name := range balance.Peers {
+		tags[i] = peerTag(name)
+	}
+	return option.Outbound{
+		Type: loadBalanceType,
+		Tag:  loadBalanceTag,
+		Options: &LoadBalanceOptions{
+			Outbounds: tags,
+			Strategy:  balance.Strategy,
+			URL:       balance.URL,
+			Interval:  badoption.Duration(time.Duration(balance.Interval) * time.Second),
+		},
+	}
+}
+
+// balancer 在多个节点间分配连接，跳过健康检查失败的节点
+type balancer struct {
+	outbound.Adapter
+	ctx      context.Context
+	outbound adapter.OutboundManager
+	logger   log.ContextLogger
+	tags     []string
+	strategy string
+	url      string
+	interval time.Duration
+	members  []*balanceMember
+	next     atomic.Uint32
+	cancel   context.CancelFunc
+	done     sync.WaitGroup
+}
+
+type balanceMember struct {
+	adapter.Outbound
+	healthy atomic.Bool
+	up      atomic.Int64
+	down    atomic.Int64
+}
+
+func newBalancer(ctx context.Context, router adapter.Router, logger log.ContextLogger, tag string, options LoadBalanceOptions) (adapter.Outbound, error) {
+	if len(options.Outbounds) == 0 {
+		return nil, E.New("missing tags")
+	}
+	switch options.Strategy {
+	case config.BalanceRoundRobin, config.BalanceConsistentHash:
+	default:
+		return nil, E.New("unknown strategy: ", options.Strategy)
+	}
+	interval := time.Duration(options.Interval)
+	if interval == 0 {
+		interval = C.DefaultURLTestInterval
+	}
+	return &balancer{
+		Adapter:  outbound.NewAdapter(loadBalanceType, tag, []string{N.NetworkTCP, N.NetworkUDP}, options.Outbounds),
+		ctx:      ctx,
+		outbound: service.FromContext[adapter.OutboundManager](ctx),
+		logger:   logger,
+		tags:     options.Outbounds,
+		strategy: options.Strategy,
+		url:      options.URL,
+		interval: interval,
+	}, nil
+}
+
+func (b *balancer) Start() error {
+	for i, tag := range b.tags {
+		detour, loaded := b.outbound.Outbound(tag)
+		if !loaded {
+			return E.New("outbound ", i, " not found: ", tag)
+		}
+		member := &balanceMember{Outbound: detour}
+		member.healthy.Store(true)
+		b.members = append(b.members, member)
+	}
+	return nil
+}
+
+func (b *balancer) PostStart() error {
+	ctx, cancel := context.WithCancel(b.ctx)
+	b.cancel = cancel
+	b.done.Add(1)
+	go func() {
+		defer b.done.Done()
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			b.check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *balancer) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+		b.done.Wait()
+	}
+	return nil
+}
+
+// check 并发探测全部节点并更新健康状态
+func (b *balancer) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, member := range b.members {
+		wg.Add(1)
+		go func(member *balanceMember) {
+			defer wg.Done()
+			testCtx, cancel := context.WithTimeout(ctx, C.TCPTimeout)
+			defer cancel()
+			_, err := urltest.URLTest(testCtx, b.url, member)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && member.healthy.Swap(false) {
+				b.logger.Warn("balance member ", member.Tag(), " unhealthy: ", err)
+			} else if err == nil {
+				member.healthy.Store(true)
+			}
+		}(member)
+	}
+	wg.Wait()
+}
+
+// pick 选择节点，全部不健康时在全部节点中选择
+func (b *balancer) pick(ctx context.Context, destination M.Socksaddr) *balanceMember {
+	candidates := make([]*balanceMember, 0, len(b.members))
+	for _, member := range b.members {
+		if member.healthy.Load() {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = b.members
+	}
+	if b.strategy == config.BalanceRoundRobin {
+		return candidates[int(b.next.Add(1)-1)%len(candidates)]
+	}
+	// 最高随机权重哈希，节点增减时只影响该节点上的目标
+	key := destination.AddrString()
+	if metadata := adapter.ContextFrom(ctx); metadata != nil && metadata.Domain != "" {
+		key = metadata.Domain
+	}
+	var selected *balanceMember
+	var maxWeight uint64
+	for _, member := range candidates {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(key))
+		_, _ = hash.Write([]byte(member.Tag()))
+		if weight := hash.Sum64(); selected == nil || weight > maxWeight {
+			selected, maxWeight = member, weight
+		}
+	}
+	return selected
+}
+
+func (b *balancer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	member := b.pick(ctx, destination)
+	conn, err := member.DialContext(ctx, network, destination)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewInt64CounterConn(conn, []*atomic.Int64{&member.down}, []*atomic.Int64{&member.up}), nil
+}
+
+func (b *balancer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	member := b.pick(ctx, destination)
+	conn, err := member.ListenPacket(ctx, destination)
+	if err != nil {
+		return nil, err
+	}
+	return &counterPacketConn{PacketConn: conn, up: &member.up, down: &member.down}, nil
+}
+
+// Stats 返回各节点的健康状态和流量
+func (b *balancer) Stats() []data.BalanceMember {
+	stats := make([]data.BalanceMember, len(b.members))
+	for i, member := range b.members {
+		stats[i] = data.BalanceMember{
+			Name:    member.Tag()[len(peerTag("")):],
+			Healthy: member.healthy.Load(),
+			Up:      uint64(member.up.Load()),
+			Down:    uint64(member.down.Load()),
+		}
+	}
+	return stats
+}
+
+type counterPacketConn struct {
+	net.PacketConn
+	up   *atomic.Int64
+	down *atomic.Int64
+}
+
+func (c *counterPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.down.Add(int64(n))
+	return n, addr, err
+}
+
+func (c *counterPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	c.up.Add(int64(n))
+	return n, err
+}
+
+func (c *counterPacketConn) Upstream() any {
+	return c.PacketConn
+}
@@ -3,17 +3,26 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/netip"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/danbai225/gpp/backend/config"
+	"github.com/danbai225/gpp/backend/data"
 	"github.com/google/uuid"
 	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	C "github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/include"
 	"github.com/sagernet/sing-box/option"
 	dns "github.com/sagernet/sing-dns"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/auth"
 	"github.com/sagernet/sing/common/json/badoption"
+	"github.com/sagernet/sing/service"
 )
 
 func getOUt(peer *config.Peer) option.Outbound {
@@ -23,6 +32,7 @@ func getOUt(peer *config.Peer) option.Outbound {
 		out = option.Outbound{
 			Type: "shadowsocks",
 			Options: &option.ShadowsocksOutboundOptions{
+				DialerOptions: detourOptions(peer),
 				ServerOptions: option.ServerOptions{
 					Server:     peer.Addr,
 					ServerPort: peer.Port,
@@ -46,6 +56,7 @@ func getOUt(peer *config.Peer) option.Outbound {
 		out = option.Outbound{
 			Type: "socks",
 			Options: &option.SOCKSOutboundOptions{
+				DialerOptions: detourOptions(peer),
 				ServerOptions: option.ServerOptions{
 					Server:     peer.Addr,
 					ServerPort: peer.Port,
@@ -62,6 +73,7 @@ func getOUt(peer *config.Peer) option.Outbound {
 		out = option.Outbound{
 			Type: "hysteria2",
 			Options: &option.Hysteria2OutboundOptions{
+				DialerOptions: detourOptions(peer),
 				ServerOptions: option.ServerOptions{
 					Server:     peer.Addr,
 					ServerPort: peer.Port,
@@ -86,6 +98,7 @@ func getOUt(peer *config.Peer) option.Outbound {
 		out = option.Outbound{
 			Type: "vless",
 			Options: &option.VLESSOutboundOptions{
+				DialerOptions: detourOptions(peer),
 				ServerOptions: option.ServerOptions{
 					Server:     peer.Addr,
 					ServerPort: peer.Port,
@@ -104,193 +117,415 @@ func getOUt(peer *config.Peer) option.Outbound {
 	out.Tag = uuid.New().String()
 	return out
 }
-func Client(gamePeer, httpPeer *config.Peer, proxyDNS, localDNS string, rules []option.Rule) (*box.Box, error) {
-	proxyOut := getOUt(gamePeer)
-	httpOut := proxyOut
-	if httpPeer != nil {
-		httpOut = getOUt(httpPeer)
-	}
-	httpOut.Tag = "http"
-	proxyOut.Tag = "proxy"
-	
-	// 创建带有正确注册表的 context
-	ctx := context.Background()
-	ctx = include.Context(ctx)
-	
-	options := box.Options{
+
+// Box sing-box 实例及其连接追踪器
+type Box struct {
+	*box.Box
+	Tracker *Tracker
+	DNSLog  *DNSLog
+	rates   *rateSampler
+	// rateHistory 保留的速率历史时长
+	rateHistory time.Duration
+	clash       adapter.ClashServer
+	mode        string
+	// selected 选择器 tag 到节点 tag，启动后恢复，避免被缓存文件中的选择覆盖
+	selected map[string]string
+	failover *config.Failover
+	watcher  *fallbackWatcher
+}
+
+// Start 启动实例，并恢复配置的路由模式，避免被缓存文件中的模式覆盖
+// 实例已启动但恢复失败时关闭实例，避免 TUN 和监听端口残留
+func (b *Box) Start() error {
+	if err := b.Box.Start(); err != nil {
+		return err
+	}
+	if err := b.restore(); err != nil {
+		_ = b.Close()
+		return err
+	}
+	return nil
+}
+
+// restore 恢复选择的节点和路由模式，并启动故障转移探测和速率采样
+func (b *Box) restore() error {
+	for group, tag := range b.selected {
+		if err := b.selectOutbound(group, tag); err != nil {
+			return err
+		}
+	}
+	if b.failover != nil && b.failover.Type == config.FailoverFallback {
+		b.watcher = newFallbackWatcher(b.Outbound(), b.failover)
+		b.watcher.Start()
+	}
+	b.rates = newRateSampler(b.Tracker, b.rateHistory)
+	b.rates.Start()
+	if b.mode == "" {
+		return nil
+	}
+	return b.SetMode(b.mode)
+}
+
+// Close 停止故障转移探测和速率采样并关闭实例
+func (b *Box) Close() error {
+	if b.watcher != nil {
+		b.watcher.Close()
+	}
+	if b.rates != nil {
+		b.rates.Close()
+	}
+	return b.Box.Close()
+}
+
+// Rates 返回各出站的当前速率、峰值和最近的历史
+func (b *Box) Rates() data.Rates {
+	if b.rates == nil {
+		return data.Rates{}
+	}
+	return b.rates.Rates()
+}
+
+// ActivePeer 返回游戏流量当前使用的节点名称，故障转移时为节点组中选中的节点
+func (b *Box) ActivePeer() string {
+	tag := b.now("proxy")
+	if tag == failoverTag {
+		tag = b.now(failoverTag)
+	}
+	return strings.TrimPrefix(tag, peerTag(""))
+}
+
+// BalanceStats 返回负载均衡各节点的健康状态和流量，未启用时为空
+func (b *Box) BalanceStats() []data.BalanceMember {
+	out, loaded := b.Outbound().Outbound(loadBalanceTag)
+	if !loaded {
+		return nil
+	}
+	if group, ok := out.(*balancer); ok {
+		return group.Stats()
+	}
+	return nil
+}
+
+// KillSwitchActive 断网保护是否正在拦截游戏流量，未启用时为 false
+func (b *Box) KillSwitchActive() bool {
+	out, loaded := b.Outbound().Outbound(killSwitchTag)
+	if !loaded {
+		return false
+	}
+	if guard, ok := out.(*killSwitch); ok {
+		return guard.Blocked()
+	}
+	return false
+}
+
+// activeOutbound 沿选择器和节点组找到实际使用的出站
+func activeOutbound(manager adapter.OutboundManager, tag string) string {
+	for {
+		out, loaded := manager.Outbound(tag)
+		if !loaded {
+			return tag
+		}
+		group, isGroup := out.(adapter.OutboundGroup)
+		if !isGroup {
+			return tag
+		}
+		tag = group.Now()
+	}
+}
+
+func (b *Box) now(group string) string {
+	out, loaded := b.Outbound().Outbound(group)
+	if !loaded {
+		return ""
+	}
+	if selector, ok := out.(interface{ Now() string }); ok {
+		return selector.Now()
+	}
+	return ""
+}
+
+// SelectPeer 切换选择器 proxy 或 http 使用的节点，运行中立即生效
+func (b *Box) SelectPeer(group string, peer *config.Peer) error {
+	tag := peerTag(peer.Name)
+	if err := b.selectOutbound(group, tag); err != nil {
+		return err
+	}
+	b.selected[group] = tag
+	return nil
+}
+
+func (b *Box) selectOutbound(group, tag string) error {
+	out, loaded := b.Outbound().Outbound(group)
+	if !loaded {
+		return fmt.Errorf("outbound not found: %s", group)
+	}
+	selector, ok := out.(interface{ SelectOutbound(string) bool })
+	if !ok {
+		return fmt.Errorf("outbound %s is not a selector", group)
+	}
+	if !selector.SelectOutbound(tag) {
+		return fmt.Errorf("peer not found: %s", tag)
+	}
+	return nil
+}
+
+// Mode 返回当前路由模式
+func (b *Box) Mode() string {
+	if b.clash == nil {
+		return b.mode
+	}
+	return b.clash.Mode()
+}
+
+// SetMode 切换路由模式，无需重建 TUN
+func (b *Box) SetMode(mode string) error {
+	server, ok := b.clash.(interface{ SetMode(string) })
+	if !ok {
+		return errors.New("clash api is not included in this build")
+	}
+	server.SetMode(mode)
+	if !strings.EqualFold(b.clash.Mode(), mode) {
+		return fmt.Errorf("unknown mode: %s", mode)
+	}
+	b.mode = mode
+	return nil
+}
+
+func Client(gamePeer, httpPeer *config.Peer, conf *config.Config, profiles []*config.GameProfile) (*Box, error) {
+	options, err := buildOptions(gamePeer, httpPeer, conf, profiles)
+	if err != nil {
+		return nil, err
+	}
+	if config.Debug.Load() {
+		options.Log = &option.LogOptions{
+			Disabled:     false,
+			Level:        "trace",
+			Output:       "debug.log",
+			Timestamp:    true,
+			DisableColor: true,
+		}
+		indent, _ := json.MarshalIndent(options, "", " ")
+		_ = os.WriteFile("sing.json", indent, os.ModePerm)
+	}
+	dnsLog := NewDNSLog()
+	ctx := service.ContextWithPtr(newContext(), dnsLog)
+	if options.Experimental.ClashAPI == nil {
+		service.MustRegister[adapter.ClashServer](ctx, newModeServer(ctx))
+	}
+	instance, err := box.New(box.Options{
 		Context: ctx,
-		Options: option.Options{
-			Log: &option.LogOptions{
-				Disabled: true,
-			},
-			DNS: &option.DNSOptions{
-				RawDNSOptions: option.RawDNSOptions{
-					Servers: []option.DNSServerOptions{
-						{
-							Type: "legacy",
-							Tag:  "proxyDns",
-							Options: &option.LegacyDNSServerOptions{
-								Address:  proxyDNS,
-								Detour:   "proxy",
-								Strategy: option.DomainStrategy(dns.DomainStrategyUseIPv4),
-							},
-						},
-						{
-							Type: "legacy",
-							Tag:  "localDns",
-							Options: &option.LegacyDNSServerOptions{
-								Address:  localDNS,
-								Detour:   "direct",
-								Strategy: option.DomainStrategy(dns.DomainStrategyUseIPv4),
-							},
-						},
-						{
-							Type: "legacy",
-							Tag:  "block",
-							Options: &option.LegacyDNSServerOptions{
-								Address:  "rcode://success",
-								Strategy: option.DomainStrategy(dns.DomainStrategyUseIPv4),
-							},
-						},
-					},
-					Rules: []option.DNSRule{
-						{
-							Type: "default",
-							DefaultOptions: option.DefaultDNSRule{
-								RawDefaultDNSRule: option.RawDefaultDNSRule{
-									Domain: badoption.Listable[string]{
-										gamePeer.Domain(),
-										httpPeer.Domain(),
-									},
-								},
-								DNSRuleAction: option.DNSRuleAction{
-									RouteOptions: option.DNSRouteActionOptions{
-										Server: "localDns",
-									},
-								},
-							},
-						},
-						// Route Chinese domains to local DNS
-						{
-							Type: "default",
-							DefaultOptions: option.DefaultDNSRule{
-								RawDefaultDNSRule: option.RawDefaultDNSRule{
-									DomainSuffix: badoption.Listable[string]{
-										".cn",
-										".xn--fiqs8s", // .中国
-										".xn--fiqz9s", // .中國
-									},
-								},
-								DNSRuleAction: option.DNSRuleAction{
-									RouteOptions: option.DNSRouteActionOptions{
-										Server: "localDns",
-									},
-								},
-							},
-						},
-					},
-					DNSClientOptions: option.DNSClientOptions{
-						DisableCache: false,
-					},
+		Options: options,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tracker := NewTracker()
+	instance.Router().AppendTracker(tracker)
+	return &Box{
+		Box:         instance,
+		Tracker:     tracker,
+		DNSLog:      dnsLog,
+		clash:       service.FromContext[adapter.ClashServer](ctx),
+		mode:        conf.Mode,
+		rateHistory: time.Duration(conf.RateHistory) * time.Minute,
+		selected: map[string]string{
+			"proxy": selectedTag(options, "proxy"),
+			"http":  selectedTag(options, "http"),
+		},
+		failover: conf.Failover,
+	}, nil
+}
+
+// newContext 创建带有正确注册表的 context，包含自定义的负载均衡出站、DNS 日志出站、断网保护出站和客户端子网 DNS 服务器
+func newContext() context.Context {
+	outbounds := include.OutboundRegistry()
+	registerBalancer(outbounds)
+	registerDNSLog(outbounds)
+	registerKillSwitch(outbounds)
+	transports := include.DNSTransportRegistry()
+	registerPeerSubnet(transports)
+	ctx := box.Context(context.Background(), include.InboundRegistry(), outbounds, include.EndpointRegistry(), &tracedRegistry{transports}, include.ServiceRegistry())
+	return service.ContextWithDefaultRegistry(ctx)
+}
+
+// buildOptions 根据节点、配置和启用的游戏配置生成 sing-box 配置
+func buildOptions(gamePeer, httpPeer *config.Peer, conf *config.Config, profiles []*config.GameProfile) (option.Options, error) {
+	inbounds, err := buildInbounds(conf)
+	if err != nil {
+		return option.Options{}, err
+	}
+	strategy := dnsStrategy(conf)
+	// 加载配置时保留已保存的地址，不再支持的地址在启动时报错，需在设置中修改
+	if err := config.NewConfigValidator().ValidateDNSAddresses(conf); err != nil {
+		return option.Options{}, err
+	}
+	proxyDNS, _ := config.ParseDNSAddress(conf.ProxyDNS)
+	localDNS, _ := config.ParseDNSAddress(conf.LocalDNS)
+	if gamePeer == nil {
+		return option.Options{}, errors.New("game peer not selected")
+	}
+	if httpPeer == nil {
+		httpPeer = gamePeer
+	}
+	// 全部节点放入 proxy、http 两个选择器，运行中切换节点无需重建 TUN
+	peers := peerList(conf.PeerList, gamePeer, httpPeer)
+	peerOuts := make([]option.Outbound, 0, len(peers))
+	peerTags := make([]string, 0, len(peers))
+	peerDomains := badoption.Listable[string]{}
+	for _, peer := range peers {
+		if _, err := config.DetourChain(peer, peers); err != nil {
+			return option.Options{}, err
+		}
+		out := getOUt(peer)
+		out.Tag = peerTag(peer.Name)
+		peerOuts = append(peerOuts, out)
+		peerTags = append(peerTags, out.Tag)
+		if peer.Protocol != "direct" && !common.Contains(peerDomains, peer.Domain()) {
+			peerDomains = append(peerDomains, peer.Domain())
+		}
+	}
+	proxyOut := selectorOut("proxy", peerTags, peerTag(gamePeer.Name))
+	if conf.Failover != nil {
+		// 启用故障转移时游戏流量默认走节点组
+		peerOuts = append(peerOuts, failoverOut(conf.Failover))
+		proxyOut = selectorOut("proxy", append([]string{failoverTag}, peerTags...), failoverTag)
+	}
+	httpOut := selectorOut("http", peerTags, peerTag(httpPeer.Name))
+	if conf.LoadBalance != nil {
+		// 启用负载均衡时 HTTP 流量默认分摊到多个节点
+		peerOuts = append(peerOuts, balanceOut(conf.LoadBalance))
+		httpOut = selectorOut("http", append([]string{loadBalanceTag}, peerTags...), loadBalanceTag)
+	}
+
+	options := option.Options{
+		Log: &option.LogOptions{
+			Disabled: true,
+		},
+		DNS: &option.DNSOptions{
+			RawDNSOptions: option.RawDNSOptions{
+				Servers: []option.DNSServerOptions{
+					dnsServer("proxyDns", proxyDNS, "proxy"),
+					dnsServer("localDns", localDNS, "direct"),
+				},
+				DNSClientOptions: option.DNSClientOptions{
+					Strategy:     strategy,
+					DisableCache: false,
 				},
 			},
-			Inbounds: []option.Inbound{
+		},
+		Inbounds: inbounds,
+		Route: &option.RouteOptions{
+			AutoDetectInterface: true,
+			Rules: []option.Rule{
 				{
-					Type: "tun",
-					Tag:  "tun-in",
-					Options: &option.TunInboundOptions{
-						InterfaceName: "utun225",
-						MTU:           1420,
-						Address: badoption.Listable[netip.Prefix]{
-							netip.MustParsePrefix("172.25.0.1/30"),
+					Type: "default",
+					DefaultOptions: option.DefaultRule{
+						RawDefaultRule: option.RawDefaultRule{
+							Protocol: badoption.Listable[string]{"dns"},
 						},
-						AutoRoute:              true,
-						StrictRoute:            true,
-						EndpointIndependentNat: true,
-						UDPTimeout:             option.UDPTimeoutCompat(time.Second * 300),
-						Stack:                  "system",
-						InboundOptions: option.InboundOptions{
-							SniffEnabled: true,
-						},
-					},
-				},
-				{
-					Type: "socks",
-					Tag:  "socks-in",
-					Options: &option.SocksInboundOptions{
-						ListenOptions: option.ListenOptions{
-							ListenPort: 5123,
-							InboundOptions: option.InboundOptions{
-								SniffEnabled: true,
+						RuleAction: option.RuleAction{
+							Action: C.RuleActionTypeRoute,
+							RouteOptions: option.RouteActionOptions{
+								Outbound: "dns_out",
 							},
 						},
 					},
 				},
-			},
-			Route: &option.RouteOptions{
-				AutoDetectInterface: true,
-				Rules: []option.Rule{
-					{
-						Type: "default",
-						DefaultOptions: option.DefaultRule{
-							RawDefaultRule: option.RawDefaultRule{
-								Protocol: badoption.Listable[string]{"dns"},
-							},
-							RuleAction: option.RuleAction{
-								RouteOptions: option.RouteActionOptions{
-									Outbound: "dns_out",
-								},
-							},
+				{
+					Type: "default",
+					DefaultOptions: option.DefaultRule{
+						RawDefaultRule: option.RawDefaultRule{
+							Inbound: badoption.Listable[string]{"dns_in"},
 						},
-					},
-					{
-						Type: "default",
-						DefaultOptions: option.DefaultRule{
-							RawDefaultRule: option.RawDefaultRule{
-								Inbound: badoption.Listable[string]{"dns_in"},
-							},
-							RuleAction: option.RuleAction{
-								RouteOptions: option.RouteActionOptions{
-									Outbound: "dns_out",
-								},
+						RuleAction: option.RuleAction{
+							Action: C.RuleActionTypeRoute,
+							RouteOptions: option.RouteActionOptions{
+								Outbound: "dns_out",
 							},
 						},
 					},
 				},
 			},
-			Outbounds: []option.Outbound{
-				proxyOut,
-				httpOut,
-				{
-					Type: "block",
-					Tag:  "block",
-				},
-				{
-					Type: "direct",
-					Tag:  "direct",
-				}, {
-					Type: "dns",
-					Tag:  "dns_out",
-				},
+		},
+		Outbounds: []option.Outbound{
+			proxyOut,
+			httpOut,
+			{
+				Type: "block",
+				Tag:  "block",
+			},
+			{
+				Type: "direct",
+				Tag:  "direct",
 			},
 		},
 	}
+	options.Outbounds = append(options.Outbounds, peerOuts...)
 
-	options.Options.Route.Rules = append(options.Options.Route.Rules, []option.Rule{
-		{
+	if conf.BlockIPv6 {
+		// 拦截全部 IPv6 流量，防止绕过隧道泄露
+		options.Route.Rules = append(options.Route.Rules, option.Rule{
 			Type: "default",
 			DefaultOptions: option.DefaultRule{
 				RawDefaultRule: option.RawDefaultRule{
-					Network: badoption.Listable[string]{"udp"},
-					Port:    badoption.Listable[uint16]{443},
+					IPVersion: 6,
 				},
 				RuleAction: option.RuleAction{
+					Action: C.RuleActionTypeRoute,
 					RouteOptions: option.RouteActionOptions{
 						Outbound: "block",
 					},
 				},
 			},
+		})
+	}
+	// 局域网直连，游戏配置优先于全局 QUIC 拦截和直连列表
+	options.Route.Rules = append(options.Route.Rules, option.Rule{
+		Type: "default",
+		DefaultOptions: option.DefaultRule{
+			RawDefaultRule: option.RawDefaultRule{
+				IPCIDR: badoption.Listable[string]{
+					"192.168.0.0/16",
+					"10.0.0.0/8",
+					"172.16.0.0/12",
+					"127.0.0.0/8",
+					"::1/128",
+					"fc00::/7",
+					"fe80::/10",
+				},
+			},
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: "direct",
+				},
+			},
+		},
+	})
+	// 直连、全局模式在局域网直连之后生效，规则模式继续匹配后续规则
+	options.Route.Rules = append(options.Route.Rules,
+		routeRule(option.RawDefaultRule{ClashMode: config.ModeDirect}, "direct"),
+		routeRule(option.RawDefaultRule{ClashMode: config.ModeGlobal}, "proxy"),
+	)
+	options.Route.Rules = append(options.Route.Rules, profileRules(profiles)...)
+	options.Route.Rules = append(options.Route.Rules, option.Rule{
+		Type: "default",
+		DefaultOptions: option.DefaultRule{
+			RawDefaultRule: option.RawDefaultRule{
+				Network: badoption.Listable[string]{"udp"},
+				Port:    badoption.Listable[uint16]{443},
+			},
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: "block",
+				},
+			},
 		},
+	})
+	// 进程分流优先于直连列表和自定义规则
+	options.Route.Rules = append(options.Route.Rules, processSplitRules(conf.ProcessSplit)...)
+	options.Route.Rules = append(options.Route.Rules, []option.Rule{
 		{
 			Type: "default",
 			DefaultOptions: option.DefaultRule{
@@ -309,128 +544,277 @@ func Client(gamePeer, httpPeer *config.Peer, proxyDNS, localDNS string, rules []
 					},
 				},
 				RuleAction: option.RuleAction{
+					Action: C.RuleActionTypeRoute,
 					RouteOptions: option.RouteActionOptions{
 						Outbound: "direct",
 					},
 				},
 			},
 		},
-		{
-			Type: "default",
-			DefaultOptions: option.DefaultRule{
-				RawDefaultRule: option.RawDefaultRule{
-					IPCIDR: badoption.Listable[string]{
-						"192.168.0.0/16",
-						"10.0.0.0/8",
-						"172.16.0.0/12",
-						"127.0.0.0/8",
-						"::1/128",
-						"fc00::/7",
-						"fe80::/10",
-					},
-				},
-				RuleAction: option.RuleAction{
-					RouteOptions: option.RouteActionOptions{
-						Outbound: "direct",
-					},
-				},
+	}...)
+	options.Route.Rules = append(options.Route.Rules, conf.Rules...)
+	// 连接列表显示来源进程，进程分流也依赖进程查找
+	options.Route.FindProcess = true
+	// 路由模式通过 clash_mode 规则切换，未配置控制接口时由 modeServer 提供模式，无需 with_clash_api 标签
+	// 模式列表只包含规则引用的模式和默认模式，默认模式固定为 rule，启动后再切换到配置的模式
+	options.Experimental = &option.ExperimentalOptions{}
+	if conf.ClashAPI != nil {
+		options.Experimental.ClashAPI = &option.ClashAPIOptions{
+			ExternalController: conf.ClashAPI.Listen,
+			Secret:             conf.ClashAPI.Secret,
+			DefaultMode:        config.ModeRule,
+		}
+	}
+	if len(conf.RuleSets) > 0 {
+		options.Route.RuleSet = buildRuleSets(conf.RuleSets)
+	}
+	// 静态解析优先，其次节点域名使用本地 DNS，避免经代理解析节点自身，自定义规则在其余内置规则之前
+	if conf.DNS != nil {
+		options.DNS.Servers = append(options.DNS.Servers, userDNSServers(conf.DNS)...)
+		options.DNS.Rules = append(options.DNS.Rules, hostsRules(conf.DNS)...)
+	}
+	if len(peerDomains) > 0 {
+		options.DNS.Rules = append(options.DNS.Rules, dnsRouteRule(option.RawDefaultDNSRule{Domain: peerDomains}, "localDns"))
+	}
+	if conf.DNS != nil {
+		options.DNS.Rules = append(options.DNS.Rules, userDNSRules(conf.DNS)...)
+	}
+	options.DNS.Rules = append(options.DNS.Rules,
+		// 直连模式下全部使用本地 DNS
+		dnsRouteRule(option.RawDefaultDNSRule{ClashMode: config.ModeDirect}, "localDns"),
+		// Route Chinese domains to local DNS
+		dnsRouteRule(option.RawDefaultDNSRule{
+			DomainSuffix: badoption.Listable[string]{
+				".cn",
+				".xn--fiqs8s", // .中国
+				".xn--fiqz9s", // .中國
 			},
-		},
-		{
-			Type: "default",
-			DefaultOptions: option.DefaultRule{
-				RawDefaultRule: option.RawDefaultRule{
-					IPCIDR: badoption.Listable[string]{
-						"85.236.96.0/21",
-						"188.42.95.0/24",
-						"188.42.147.0/24",
-					},
-				},
-				RuleAction: option.RuleAction{
-					RouteOptions: option.RouteActionOptions{
-						Outbound: "direct",
-					},
-				},
+		}, "localDns"),
+	)
+	// 节点配置了客户端子网时，代理 DNS 由按当前节点附加子网的服务器包装
+	if server, ok := peerSubnetServer(peers); ok {
+		for i := range options.DNS.Servers {
+			if options.DNS.Servers[i].Tag == server.Tag {
+				options.DNS.Servers[i].Tag = proxyDNSUpstream
+			}
+		}
+		options.DNS.Servers = append(options.DNS.Servers, server)
+		options.DNS.Final = server.Tag
+	}
+	if conf.FakeIP != nil {
+		options.DNS.Servers = append(options.DNS.Servers, fakeIPServer(conf.FakeIP))
+		options.DNS.Rules = append(options.DNS.Rules, fakeIPRules(conf.FakeIP, strategy)...)
+	}
+	// DNS 请求由记录日志的出站处理，按相同规则区分应答来源
+	options.Outbounds = append(options.Outbounds, dnsLogOut(directDNSServers(conf), options.DNS.Rules))
+	// 远程规则集和 FakeIP 映射缓存到磁盘，重启后无需重新下载，已分配的虚假地址仍然有效
+	if len(conf.RuleSets) > 0 || conf.FakeIP != nil {
+		options.Experimental.CacheFile = &option.CacheFileOptions{
+			Enabled:     true,
+			Path:        config.DataPath("cache.db"),
+			StoreFakeIP: conf.FakeIP != nil,
+		}
+	}
+	// http
+	// http 流量走 http 选择器，选中节点与游戏节点相同时等同于默认出站
+	options.Route.Rules = append(options.Route.Rules, option.Rule{
+		Type: "default",
+		DefaultOptions: option.DefaultRule{
+			RawDefaultRule: option.RawDefaultRule{
+				Protocol: badoption.Listable[string]{"http"},
 			},
-		},
-		{
-			Type: "default",
-			DefaultOptions: option.DefaultRule{
-				RawDefaultRule: option.RawDefaultRule{
-					DomainSuffix: badoption.Listable[string]{
-						"vivox.com",
-						"cm.steampowered.com",
-						"steamchina.com",
-						"steamcontent.com",
-						"steamserver.net",
-						"steamusercontent.com",
-						"csgo.wmsj.cn",
-						"dl.steam.clngaa.com",
-						"dl.steam.ksyna.com",
-						"dota2.wmsj.cn",
-						"st.dl.bscstorage.net",
-						"st.dl.eccdnx.com",
-						"st.dl.pinyuncloud.com",
-						"steampipe.steamcontent.tnkjmec.com",
-						"steampowered.com.8686c.com",
-						"steamstatic.com.8686c.com",
-						"wmsjsteam.com",
-						"xz.pphimalayanrt.com",
-					},
-				},
-				RuleAction: option.RuleAction{
-					RouteOptions: option.RouteActionOptions{
-						Outbound: "direct",
-					},
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: httpOut.Tag,
 				},
 			},
 		},
-	}...)
-	options.Options.Route.Rules = append(options.Options.Route.Rules, rules...)
-	// http
-	if httpPeer != nil && httpPeer.Name != gamePeer.Name {
-		options.Options.Route.Rules = append(options.Options.Route.Rules, option.Rule{
-			Type: "default",
-			DefaultOptions: option.DefaultRule{
-				RawDefaultRule: option.RawDefaultRule{
-					Protocol: badoption.Listable[string]{"http"},
-				},
-				RuleAction: option.RuleAction{
-					RouteOptions: option.RouteActionOptions{
-						Outbound: httpOut.Tag,
-					},
-				},
+	})
+	options.Route.Rules = append(options.Route.Rules, option.Rule{
+		Type: "default",
+		DefaultOptions: option.DefaultRule{
+			RawDefaultRule: option.RawDefaultRule{
+				Network: badoption.Listable[string]{"tcp"},
+				Port:    badoption.Listable[uint16]{80, 443, 8080, 8443},
 			},
-		})
-		options.Options.Route.Rules = append(options.Options.Route.Rules, option.Rule{
-			Type: "default",
-			DefaultOptions: option.DefaultRule{
-				RawDefaultRule: option.RawDefaultRule{
-					Network: badoption.Listable[string]{"tcp"},
-					Port:    badoption.Listable[uint16]{80, 443, 8080, 8443},
-				},
-				RuleAction: option.RuleAction{
-					RouteOptions: option.RouteActionOptions{
-						Outbound: httpOut.Tag,
-					},
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: httpOut.Tag,
 				},
 			},
-		})
+		},
+	})
+	if conf.KillSwitch != nil {
+		// 游戏流量经断网保护出站，节点不可用时拦截而不是回落直连
+		applyKillSwitch(&options, conf.KillSwitch)
 	}
-	if config.Debug.Load() {
-		options.Log = &option.LogOptions{
-			Disabled:     false,
-			Level:        "trace",
-			Output:       "debug.log",
-			Timestamp:    true,
-			DisableColor: true,
+	return options, nil
+}
+
+// buildRuleSets 复制规则集配置，本地规则集的相对路径以配置目录为准
+func buildRuleSets(ruleSets []option.RuleSet) []option.RuleSet {
+	result := make([]option.RuleSet, len(ruleSets))
+	copy(result, ruleSets)
+	for i := range result {
+		if result[i].Type == C.RuleSetTypeLocal {
+			result[i].LocalOptions.Path = config.DataPath(result[i].LocalOptions.Path)
 		}
-		indent, _ := json.MarshalIndent(options, "", " ")
-		_ = os.WriteFile("sing.json", indent, os.ModePerm)
 	}
-	var instance, err = box.New(options)
+	return result
+}
+
+// peerList 返回全部节点，当前选中但不在列表中的节点追加到末尾
+func peerList(list []*config.Peer, selected ...*config.Peer) []*config.Peer {
+	peers := make([]*config.Peer, 0, len(list)+len(selected))
+	peers = append(peers, list...)
+	peers = append(peers, selected...)
+	result := peers[:0]
+	names := make(map[string]bool)
+	for _, peer := range peers {
+		if names[peer.Name] {
+			continue
+		}
+		names[peer.Name] = true
+		result = append(result, peer)
+	}
+	return result
+}
+
+// detourOptions 通过前置节点连接
+func detourOptions(peer *config.Peer) option.DialerOptions {
+	if peer.Detour == "" {
+		return option.DialerOptions{}
+	}
+	return option.DialerOptions{Detour: peerTag(peer.Detour)}
+}
+
+func peerTag(name string) string {
+	return "peer:" + name
+}
+
+// selectedTag 返回选择器的默认节点
+func selectedTag(options option.Options, group string) string {
+	for _, out := range options.Outbounds {
+		if out.Tag == group {
+			return out.Options.(*option.SelectorOutboundOptions).Default
+		}
+	}
+	return ""
+}
+
+func selectorOut(tag string, outbounds []string, selected string) option.Outbound {
+	return option.Outbound{
+		Type: C.TypeSelector,
+		Tag:  tag,
+		Options: &option.SelectorOutboundOptions{
+			Outbounds:                 outbounds,
+			Default:                   selected,
+			InterruptExistConnections: true,
+		},
+	}
+}
+
+// buildInbounds 按入站模式生成入站，代理模式不创建 TUN
+func buildInbounds(conf *config.Config) ([]option.Inbound, error) {
+	local, err := buildLocalProxy(conf.LocalProxy)
 	if err != nil {
 		return nil, err
 	}
-	return instance, nil
-}
\ No newline at end of file
+	if conf.InboundMode == config.InboundModeProxy {
+		return []option.Inbound{local}, nil
+	}
+	tun := conf.Tun
+	if tun == nil {
+		tun = config.DefaultTunConfig()
+	}
+	tunAddress := badoption.Listable[netip.Prefix]{}
+	for _, address := range []string{tun.Address, tun.Inet6Address} {
+		if address == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tun address: %w", err)
+		}
+		tunAddress = append(tunAddress, prefix)
+	}
+	return []option.Inbound{
+		{
+			Type: "tun",
+			Tag:  "tun-in",
+			Options: &option.TunInboundOptions{
+				InterfaceName:          tun.InterfaceName,
+				MTU:                    tun.MTU,
+				Address:                tunAddress,
+				AutoRoute:              true,
+				StrictRoute:            tun.StrictRoute == nil || *tun.StrictRoute,
+				EndpointIndependentNat: true,
+				UDPTimeout:             option.UDPTimeoutCompat(time.Second * time.Duration(tun.UDPTimeout)),
+				Stack:                  tun.Stack,
+				InboundOptions: option.InboundOptions{
+					SniffEnabled: true,
+				},
+			},
+		},
+		local,
+	}, nil
+}
+
+// buildLocalProxy 生成本地代理入站
+func buildLocalProxy(proxy *config.LocalProxyConfig) (option.Inbound, error) {
+	if proxy == nil {
+		proxy = config.DefaultLocalProxyConfig()
+	}
+	addr, err := netip.ParseAddr(proxy.Listen)
+	if err != nil {
+		return option.Inbound{}, fmt.Errorf("invalid local proxy listen: %w", err)
+	}
+	listen := badoption.Addr(addr)
+	listenOptions := option.ListenOptions{
+		Listen:     &listen,
+		ListenPort: proxy.Port,
+		InboundOptions: option.InboundOptions{
+			SniffEnabled: true,
+		},
+	}
+	var users []auth.User
+	if proxy.Username != "" {
+		users = append(users, auth.User{Username: proxy.Username, Password: proxy.Password})
+	}
+	inbound := option.Inbound{
+		Type: proxy.Type,
+		Tag:  proxy.Type + "-in",
+	}
+	switch proxy.Type {
+	case "socks":
+		inbound.Options = &option.SocksInboundOptions{
+			ListenOptions: listenOptions,
+			Users:         users,
+		}
+	case "http", "mixed":
+		inbound.Options = &option.HTTPMixedInboundOptions{
+			ListenOptions: listenOptions,
+			Users:         users,
+		}
+	default:
+		return option.Inbound{}, fmt.Errorf("invalid local proxy type: %s", proxy.Type)
+	}
+	return inbound, nil
+}
+
+// dnsStrategy 返回配置的解析策略，屏蔽 IPv6 时只解析 IPv4
+func dnsStrategy(conf *config.Config) option.DomainStrategy {
+	if conf.BlockIPv6 {
+		return option.DomainStrategy(dns.DomainStrategyUseIPv4)
+	}
+	switch conf.DNSStrategy {
+	case "prefer_ipv4":
+		return option.DomainStrategy(dns.DomainStrategyPreferIPv4)
+	case "prefer_ipv6":
+		return option.DomainStrategy(dns.DomainStrategyPreferIPv6)
+	default:
+		return option.DomainStrategy(dns.DomainStrategyUseIPv4)
+	}
+}
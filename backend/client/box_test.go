@@ -0,0 +1,989 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/danbai225/gpp/backend/data"
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/adapter/outbound"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+func testConfig(t *testing.T, conf *config.Config) *config.Config {
+	t.Helper()
+	if err := config.NewConfigValidator().Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	return conf
+}
+
+func testPeer(name string) *config.Peer {
+	return &config.Peer{
+		Name:     name,
+		Protocol: "vless",
+		Addr:     name + ".example.com",
+		Port:     443,
+		UUID:     "5783a3e7-e373-51cd-8642-c83782b807c5",
+	}
+}
+
+func TestBuildInbounds(t *testing.T) {
+	t.Run("Tun", func(t *testing.T) {
+		conf := testConfig(t, &config.Config{})
+		options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+		if err != nil {
+			t.Fatalf("buildOptions failed: %v", err)
+		}
+		if len(options.Inbounds) != 2 || options.Inbounds[0].Type != "tun" {
+			t.Fatalf("expected tun inbound, got %+v", options.Inbounds)
+		}
+		tun := options.Inbounds[0].Options.(*option.TunInboundOptions)
+		if len(tun.Address) != 2 {
+			t.Errorf("expected IPv4 and IPv6 address, got %v", tun.Address)
+		}
+	})
+
+	t.Run("NoIPv6", func(t *testing.T) {
+		conf := testConfig(t, &config.Config{Tun: &config.TunConfig{}})
+		options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+		if err != nil {
+			t.Fatalf("buildOptions failed: %v", err)
+		}
+		tun := options.Inbounds[0].Options.(*option.TunInboundOptions)
+		if len(tun.Address) != 1 || !tun.Address[0].Addr().Is4() {
+			t.Errorf("expected only IPv4 address, got %v", tun.Address)
+		}
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		conf := testConfig(t, &config.Config{InboundMode: config.InboundModeProxy})
+		options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+		if err != nil {
+			t.Fatalf("buildOptions failed: %v", err)
+		}
+		for _, in := range options.Inbounds {
+			if in.Type == "tun" {
+				t.Fatal("proxy mode should not create tun inbound")
+			}
+		}
+		if len(options.Inbounds) != 1 || options.Inbounds[0].Type != "mixed" {
+			t.Errorf("expected a single mixed inbound, got %+v", options.Inbounds)
+		}
+	})
+
+	t.Run("LocalProxy", func(t *testing.T) {
+		conf := testConfig(t, &config.Config{LocalProxy: &config.LocalProxyConfig{
+			Type:     "socks",
+			Port:     1080,
+			Username: "user",
+			Password: "pass",
+			AllowLAN: true,
+		}})
+		options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+		if err != nil {
+			t.Fatalf("buildOptions failed: %v", err)
+		}
+		socks, ok := options.Inbounds[1].Options.(*option.SocksInboundOptions)
+		if !ok {
+			t.Fatalf("expected socks inbound, got %+v", options.Inbounds[1])
+		}
+		if socks.ListenPort != 1080 || socks.Listen.Build(netip.Addr{}).String() != "0.0.0.0" {
+			t.Errorf("unexpected listen: %v:%d", socks.Listen, socks.ListenPort)
+		}
+		if len(socks.Users) != 1 || socks.Users[0].Username != "user" {
+			t.Errorf("users not applied: %+v", socks.Users)
+		}
+	})
+}
+
+func TestBlockIPv6(t *testing.T) {
+	conf := testConfig(t, &config.Config{BlockIPv6: true, DNSStrategy: "prefer_ipv6"})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	blocked := false
+	for _, rule := range options.Route.Rules {
+		if rule.DefaultOptions.IPVersion == 6 && rule.DefaultOptions.RouteOptions.Outbound == "block" {
+			blocked = true
+		}
+	}
+	if !blocked {
+		t.Error("IPv6 block rule not found")
+	}
+	if got := dnsStrategy(conf); got != dnsStrategy(&config.Config{DNSStrategy: "ipv4_only"}) {
+		t.Errorf("block_ipv6 should force ipv4_only, got %v", got)
+	}
+}
+
+func TestRuleSets(t *testing.T) {
+	conf := testConfig(t, &config.Config{RuleSets: []option.RuleSet{
+		{
+			Type:         C.RuleSetTypeLocal,
+			Tag:          "games",
+			Format:       C.RuleSetFormatSource,
+			LocalOptions: option.LocalRuleSet{Path: "games.json"},
+		},
+	}})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if len(options.Route.RuleSet) != 1 {
+		t.Fatalf("expected 1 rule set, got %d", len(options.Route.RuleSet))
+	}
+	if path := options.Route.RuleSet[0].LocalOptions.Path; path != config.DataPath("games.json") {
+		t.Errorf("path = %s, want %s", path, config.DataPath("games.json"))
+	}
+	if conf.RuleSets[0].LocalOptions.Path != "games.json" {
+		t.Error("config should not be modified")
+	}
+	if options.Experimental == nil || !options.Experimental.CacheFile.Enabled {
+		t.Error("cache file should be enabled")
+	}
+}
+
+func TestProfileRules(t *testing.T) {
+	profiles := append(config.BuiltinProfiles(), &config.GameProfile{
+		Name:         "apex",
+		Ports:        []string{"37015", "37000:40000"},
+		ProcessNames: []string{"r5apex.exe"},
+		QUIC:         config.QUICAllow,
+		Outbound:     "proxy",
+	})
+	rules := profileRules(profiles)
+	// steam: QUIC 拦截 + 直连；apex: 进程 + 端口
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %d", len(rules))
+	}
+	quic := rules[0].DefaultOptions
+	if quic.RouteOptions.Outbound != "block" || len(quic.Port) != 1 || len(quic.DomainSuffix) == 0 {
+		t.Errorf("unexpected quic rule: %+v", quic)
+	}
+	if rules[1].DefaultOptions.RouteOptions.Outbound != "direct" || len(rules[1].DefaultOptions.IPCIDR) != 3 {
+		t.Errorf("unexpected steam rule: %+v", rules[1].DefaultOptions)
+	}
+	ports := rules[3].DefaultOptions
+	if len(ports.Port) != 1 || len(ports.PortRange) != 1 || ports.RouteOptions.Outbound != "proxy" {
+		t.Errorf("unexpected port rule: %+v", ports)
+	}
+}
+
+func TestProcessSplitRules(t *testing.T) {
+	t.Run("Include", func(t *testing.T) {
+		rules := processSplitRules(&config.ProcessSplit{
+			Mode:         config.ProcessSplitInclude,
+			ProcessNames: []string{"cs2.exe"},
+		})
+		if len(rules) != 2 {
+			t.Fatalf("expected 2 rules, got %d", len(rules))
+		}
+		if rules[0].DefaultOptions.RouteOptions.Outbound != "proxy" || rules[0].DefaultOptions.Invert {
+			t.Errorf("unexpected match rule: %+v", rules[0].DefaultOptions)
+		}
+		if rules[1].DefaultOptions.RouteOptions.Outbound != "direct" || !rules[1].DefaultOptions.Invert {
+			t.Errorf("unexpected rest rule: %+v", rules[1].DefaultOptions)
+		}
+	})
+
+	t.Run("Exclude", func(t *testing.T) {
+		rules := processSplitRules(&config.ProcessSplit{
+			Mode:         config.ProcessSplitExclude,
+			ProcessNames: []string{"chrome.exe"},
+			ProcessPaths: []string{"/usr/bin/firefox"},
+		})
+		if len(rules) != 1 || rules[0].Type != C.RuleTypeLogical {
+			t.Fatalf("expected a logical rule, got %+v", rules)
+		}
+		logical := rules[0].LogicalOptions
+		if logical.Mode != C.LogicalTypeOr || len(logical.Rules) != 2 || logical.RouteOptions.Outbound != "direct" {
+			t.Errorf("unexpected logical rule: %+v", logical)
+		}
+	})
+
+	t.Run("UIDs", func(t *testing.T) {
+		split := &config.ProcessSplit{Mode: config.ProcessSplitInclude, UIDs: []int32{1000}}
+		rules := processSplitRules(split)
+		// 不支持用户 ID 的系统没有可用条件，不分流
+		if split.SupportedUIDs() == nil {
+			if rules != nil {
+				t.Errorf("expected no rules, got %+v", rules)
+			}
+			return
+		}
+		if len(rules) != 2 || len(rules[0].DefaultOptions.UserID) != 1 {
+			t.Errorf("unexpected uid rules: %+v", rules)
+		}
+	})
+
+	conf := testConfig(t, &config.Config{ProcessSplit: &config.ProcessSplit{ProcessNames: []string{"cs2.exe"}}})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if !options.Route.FindProcess {
+		t.Error("process lookup should be enabled")
+	}
+}
+
+func TestFindProcess(t *testing.T) {
+	for _, mode := range []string{config.InboundModeTun, config.InboundModeProxy} {
+		conf := testConfig(t, &config.Config{InboundMode: mode})
+		options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+		if err != nil {
+			t.Fatalf("buildOptions failed: %v", err)
+		}
+		if !options.Route.FindProcess {
+			t.Errorf("%s: process lookup should be enabled for the connection list", mode)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	left, right := net.Pipe()
+	defer right.Close()
+	conn := tracker.RoutedConnection(context.Background(), left, adapter.InboundContext{
+		Network: "tcp",
+		Inbound: "tun-in",
+		Domain:  "example.com",
+	}, nil, nil)
+	list := tracker.Connections()
+	if len(list) != 1 || list[0].Domain != "example.com" || list[0].Rule != "final" {
+		t.Fatalf("unexpected connections: %+v", list)
+	}
+	_ = conn.Close()
+	if len(tracker.Connections()) != 0 {
+		t.Error("closed connection should be removed")
+	}
+}
+
+func TestTrackerClose(t *testing.T) {
+	tracker := NewTracker()
+	left, right := net.Pipe()
+	defer right.Close()
+	conn := tracker.RoutedConnection(context.Background(), left, adapter.InboundContext{
+		Network:     "tcp",
+		Destination: M.ParseSocksaddr("1.1.1.1:443"),
+	}, nil, &testOutbound{Adapter: outbound.NewAdapter("selector", "proxy", nil, nil)})
+	go func() {
+		_, _ = right.Write([]byte("ping"))
+		_, _ = right.Read(make([]byte, 8))
+	}()
+	_, _ = conn.Read(make([]byte, 8))
+	_, _ = conn.Write([]byte("pong!"))
+	list := tracker.Connections()
+	if len(list) != 1 || list[0].Up != 4 || list[0].Down != 5 || list[0].IP != "1.1.1.1" || list[0].Outbound != "game" {
+		t.Fatalf("unexpected connections: %+v", list)
+	}
+	if err := tracker.Close(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Connections()) != 0 {
+		t.Error("closed connection should be removed")
+	}
+	if _, err := right.Write([]byte("x")); err == nil {
+		t.Error("underlying connection should be closed")
+	}
+	if err := tracker.Close(list[0].ID); err == nil {
+		t.Error("expected not found error")
+	}
+	// 出站流量包含已关闭的连接
+	traffic := tracker.Traffic()
+	if len(traffic) != 4 || traffic[0].Outbound != "game" || traffic[0].Up != 4 || traffic[0].Down != 5 || traffic[3].Outbound != "block" {
+		t.Errorf("unexpected traffic: %+v", traffic)
+	}
+}
+
+func TestModeRules(t *testing.T) {
+	conf := testConfig(t, &config.Config{Mode: config.ModeGlobal})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, config.BuiltinProfiles())
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	// 未配置控制接口时由 modeServer 提供模式，不依赖 with_clash_api 标签
+	if options.Experimental.ClashAPI != nil {
+		t.Error("clash api should not be enabled by default")
+	}
+	modes := make(map[string]string)
+	profileIndex := -1
+	for i, rule := range options.Route.Rules {
+		if mode := rule.DefaultOptions.ClashMode; mode != "" {
+			modes[mode] = rule.DefaultOptions.RouteOptions.Outbound
+			if profileIndex != -1 {
+				t.Error("mode rules should precede profile rules")
+			}
+		}
+		if profileIndex == -1 && len(rule.DefaultOptions.DomainSuffix) > 0 && rule.DefaultOptions.DomainSuffix[0] == "vivox.com" {
+			profileIndex = i
+		}
+	}
+	if modes[config.ModeDirect] != "direct" || modes[config.ModeGlobal] != "proxy" {
+		t.Errorf("unexpected mode rules: %v", modes)
+	}
+}
+
+func TestModeServer(t *testing.T) {
+	conf := testConfig(t, &config.Config{InboundMode: config.InboundModeProxy, Mode: config.ModeGlobal})
+	b, err := Client(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	defer b.Close()
+	if _, ok := b.clash.(*modeServer); !ok {
+		t.Fatalf("unexpected clash server: %T", b.clash)
+	}
+	if err := b.SetMode("Direct"); err != nil || b.Mode() != config.ModeDirect {
+		t.Errorf("SetMode failed: %v, mode %s", err, b.Mode())
+	}
+	if err := b.SetMode("unknown"); err == nil || b.Mode() != config.ModeDirect {
+		t.Errorf("unknown mode accepted, mode %s", b.Mode())
+	}
+}
+
+func TestClashAPI(t *testing.T) {
+	conf := testConfig(t, &config.Config{ClashAPI: &config.ClashAPIConfig{Secret: "secret"}})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	api := options.Experimental.ClashAPI
+	if api.ExternalController != "127.0.0.1:9090" || api.Secret != "secret" || api.DefaultMode != config.ModeRule {
+		t.Errorf("unexpected clash api: %+v", api)
+	}
+}
+
+func TestPeerSelectors(t *testing.T) {
+	game, http := testPeer("game"), testPeer("http")
+	conf := testConfig(t, &config.Config{PeerList: []*config.Peer{game, http}})
+	options, err := buildOptions(game, http, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if got := selectedTag(options, "proxy"); got != peerTag("game") {
+		t.Errorf("proxy selected %s, want %s", got, peerTag("game"))
+	}
+	if got := selectedTag(options, "http"); got != peerTag("http") {
+		t.Errorf("http selected %s, want %s", got, peerTag("http"))
+	}
+	// 直连节点由校验器自动追加，同样可被选择
+	selector := options.Outbounds[0].Options.(*option.SelectorOutboundOptions)
+	if len(selector.Outbounds) != 3 {
+		t.Errorf("expected 3 peers in selector, got %v", selector.Outbounds)
+	}
+	domains := options.DNS.Rules[0].DefaultOptions.Domain
+	if len(domains) != 2 {
+		t.Errorf("expected peer domains resolved locally, got %v", domains)
+	}
+	if _, err := buildOptions(nil, nil, conf, nil); err == nil {
+		t.Error("expected error without game peer")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	a, b := testPeer("a"), testPeer("b")
+	for _, typ := range []string{config.FailoverURLTest, config.FailoverFallback} {
+		t.Run(typ, func(t *testing.T) {
+			conf := testConfig(t, &config.Config{
+				PeerList: []*config.Peer{a, b},
+				Failover: &config.Failover{Type: typ, Peers: []string{"b", "a"}},
+			})
+			options, err := buildOptions(a, a, conf, nil)
+			if err != nil {
+				t.Fatalf("buildOptions failed: %v", err)
+			}
+			if got := selectedTag(options, "proxy"); got != failoverTag {
+				t.Errorf("proxy selected %s, want %s", got, failoverTag)
+			}
+			var group *option.Outbound
+			for i := range options.Outbounds {
+				if options.Outbounds[i].Tag == failoverTag {
+					group = &options.Outbounds[i]
+				}
+			}
+			if group == nil {
+				t.Fatal("failover group not found")
+			}
+			switch opts := group.Options.(type) {
+			case *option.URLTestOutboundOptions:
+				if typ != config.FailoverURLTest || opts.Tolerance != 50 || opts.URL == "" {
+					t.Errorf("unexpected urltest options: %+v", opts)
+				}
+			case *option.SelectorOutboundOptions:
+				// fallback 按配置顺序，默认第一个节点
+				if typ != config.FailoverFallback || opts.Default != peerTag("b") {
+					t.Errorf("unexpected fallback options: %+v", opts)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBalance(t *testing.T) {
+	a, b := testPeer("a"), testPeer("b")
+	conf := testConfig(t, &config.Config{
+		PeerList:    []*config.Peer{a, b},
+		LoadBalance: &config.LoadBalance{Peers: []string{"a", "b"}},
+	})
+	options, err := buildOptions(a, a, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if got := selectedTag(options, "http"); got != loadBalanceTag {
+		t.Errorf("http selected %s, want %s", got, loadBalanceTag)
+	}
+	if got := selectedTag(options, "proxy"); got != peerTag("a") {
+		t.Errorf("proxy selected %s, want %s", got, peerTag("a"))
+	}
+}
+
+type testOutbound struct {
+	outbound.Adapter
+	N.Dialer
+}
+
+func TestBalancerPick(t *testing.T) {
+	newGroup := func(strategy string) *balancer {
+		group := &balancer{strategy: strategy}
+		for _, tag := range []string{"a", "b", "c"} {
+			member := &balanceMember{Outbound: &testOutbound{Adapter: outbound.NewAdapter("test", tag, nil, nil)}}
+			member.healthy.Store(true)
+			group.members = append(group.members, member)
+		}
+		return group
+	}
+	destination := M.ParseSocksaddr("example.com:443")
+
+	group := newGroup(config.BalanceRoundRobin)
+	group.members[1].healthy.Store(false)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, group.pick(context.Background(), destination).Tag())
+	}
+	if want := []string{"a", "c", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("round robin picked %v, want %v", got, want)
+	}
+
+	group = newGroup(config.BalanceConsistentHash)
+	first := group.pick(context.Background(), destination)
+	for i := 0; i < 4; i++ {
+		if group.pick(context.Background(), destination) != first {
+			t.Fatal("consistent hash picked different members for the same destination")
+		}
+	}
+	// 节点不健康时只迁移其上的目标
+	first.healthy.Store(false)
+	if group.pick(context.Background(), destination) == first {
+		t.Error("picked unhealthy member")
+	}
+	for _, member := range group.members {
+		member.healthy.Store(false)
+	}
+	if group.pick(context.Background(), destination) == nil {
+		t.Error("no member picked when all are unhealthy")
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	conf := testConfig(t, &config.Config{KillSwitch: &config.KillSwitch{}})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, config.BuiltinProfiles())
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if options.Route.Final != killSwitchTag {
+		t.Errorf("final %s, want %s", options.Route.Final, killSwitchTag)
+	}
+	for _, rule := range options.Route.Rules {
+		if rule.DefaultOptions.RouteOptions.Outbound == "proxy" || rule.LogicalOptions.RouteOptions.Outbound == "proxy" {
+			t.Errorf("rule still routes to proxy: %+v", rule)
+		}
+	}
+	// DNS 仍经过游戏选择器，断网保护只拦截连接
+	if https, ok := options.DNS.Servers[0].Options.(*option.RemoteHTTPSDNSServerOptions); !ok || https.Detour != "proxy" {
+		t.Errorf("unexpected proxyDns options: %+v", options.DNS.Servers[0].Options)
+	}
+	var opts *KillSwitchOptions
+	for _, out := range options.Outbounds {
+		if out.Tag == killSwitchTag {
+			opts = out.Options.(*KillSwitchOptions)
+		}
+	}
+	if opts == nil || opts.Outbound != "proxy" || opts.URL != config.DefaultProbeURL || time.Duration(opts.Interval) != 10*time.Second {
+		t.Errorf("unexpected kill switch options: %+v", opts)
+	}
+
+	proxy := &testOutbound{Adapter: outbound.NewAdapter("test", "proxy", nil, nil), Dialer: N.SystemDialer}
+	guard := &killSwitch{ctx: context.Background(), proxy: proxy}
+	guard.blocked.Store(true)
+	// 跳过异步重新探测
+	guard.checking.Store(true)
+	if _, err := guard.DialContext(context.Background(), N.NetworkTCP, M.ParseSocksaddr("127.0.0.1:1")); err == nil {
+		t.Error("dial should fail while blocked")
+	}
+	if _, err := guard.ListenPacket(context.Background(), M.ParseSocksaddr("127.0.0.1:1")); err == nil {
+		t.Error("listen should fail while blocked")
+	}
+}
+
+func TestKillSwitchRecheck(t *testing.T) {
+	ctx := service.ContextWithDefaultRegistry(context.Background())
+	service.MustRegister[adapter.OutboundManager](ctx, &stubOutbounds{outbounds: map[string]adapter.Outbound{
+		"proxy": &testOutbound{Adapter: outbound.NewAdapter("test", "proxy", nil, nil), Dialer: N.SystemDialer},
+	}})
+	out, err := newKillSwitch(ctx, nil, log.NewNOPFactory().Logger(), killSwitchTag, KillSwitchOptions{
+		Outbound: "proxy",
+		URL:      "http://127.0.0.1:1",
+	})
+	if err != nil {
+		t.Fatalf("newKillSwitch failed: %v", err)
+	}
+	guard := out.(*killSwitch)
+	if err := guard.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// 启动探测与连接触发的重新探测并发进行
+	destination := M.ParseSocksaddr("127.0.0.1:1")
+	go func() { _, _ = guard.DialContext(context.Background(), N.NetworkTCP, destination) }()
+	if err := guard.PostStart(); err != nil {
+		t.Fatalf("PostStart failed: %v", err)
+	}
+	for i := 0; !guard.Blocked(); i++ {
+		if i == 500 {
+			t.Fatal("unreachable peer should be blocked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, _ = guard.DialContext(context.Background(), N.NetworkTCP, destination)
+	_ = guard.Close()
+	if guard.checking.Load() {
+		t.Error("Close returned while a recheck was running")
+	}
+}
+
+func TestDetour(t *testing.T) {
+	a, b := testPeer("a"), testPeer("b")
+	a.Detour = "b"
+	conf := testConfig(t, &config.Config{PeerList: []*config.Peer{a, b}})
+	options, err := buildOptions(a, a, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	for _, out := range options.Outbounds {
+		if out.Tag == peerTag("a") {
+			if detour := out.Options.(*option.VLESSOutboundOptions).Detour; detour != peerTag("b") {
+				t.Errorf("detour %s, want %s", detour, peerTag("b"))
+			}
+		}
+	}
+
+	chain, err := chainOptions(a, conf.PeerList)
+	if err != nil {
+		t.Fatalf("chainOptions failed: %v", err)
+	}
+	if len(chain.Outbounds) != 2 || chain.Outbounds[1].Tag != peerTag("b") {
+		t.Errorf("unexpected chain outbounds: %+v", chain.Outbounds)
+	}
+
+	b.Detour = "a"
+	if _, err = buildOptions(a, a, conf, nil); err == nil {
+		t.Error("expected cycle error")
+	}
+}
+
+func TestFakeIP(t *testing.T) {
+	peer := testPeer("a")
+	conf := testConfig(t, &config.Config{
+		PeerList: []*config.Peer{peer},
+		FakeIP:   &config.FakeIPConfig{ExcludeDomains: []string{"steamserver.net"}},
+	})
+	options, err := buildOptions(peer, peer, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	var server *option.FakeIPDNSServerOptions
+	for _, s := range options.DNS.Servers {
+		if s.Tag == fakeIPTag {
+			server = s.Options.(*option.FakeIPDNSServerOptions)
+		}
+	}
+	if server == nil || server.Inet4Range.Build(netip.Prefix{}).String() != "198.18.0.0/15" {
+		t.Fatalf("unexpected fakeip server: %+v", server)
+	}
+	rules := options.DNS.Rules
+	exclude, fake := rules[len(rules)-2].DefaultOptions, rules[len(rules)-1].DefaultOptions
+	if exclude.RouteOptions.Server != "proxyDns" || len(exclude.DomainSuffix) != 1 {
+		t.Errorf("unexpected exclude rule: %+v", exclude)
+	}
+	if fake.RouteOptions.Server != fakeIPTag || len(fake.QueryType) != 2 {
+		t.Errorf("unexpected fakeip rule: %+v", fake)
+	}
+	if cache := options.Experimental.CacheFile; cache == nil || !cache.Enabled || !cache.StoreFakeIP {
+		t.Errorf("fakeip not persisted: %+v", cache)
+	}
+}
+
+func TestUserDNS(t *testing.T) {
+	peer := testPeer("a")
+	conf := testConfig(t, &config.Config{
+		PeerList: []*config.Peer{peer},
+		RuleSets: []option.RuleSet{{Type: C.RuleSetTypeRemote, Tag: "geosite-games", RemoteOptions: option.RemoteRuleSet{URL: "https://example.com/games.srs"}}},
+		DNS: &config.DNSConfig{
+			Servers: []*config.DNSServer{{Tag: "hkDns", Address: "tls://8.8.8.8"}},
+			Rules: []*config.DNSRule{{
+				Domains:      []string{"battle.net"},
+				RuleSets:     []string{"geosite-games"},
+				Server:       "hkDns",
+				ClientSubnet: "203.0.113.0/24",
+			}},
+			Hosts: map[string][]string{"login.example.com": {"10.0.0.1"}},
+		},
+	})
+	options, err := buildOptions(peer, peer, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	var servers []string
+	for _, s := range options.DNS.Servers {
+		servers = append(servers, s.Tag)
+	}
+	if want := []string{"proxyDns", "localDns", "hkDns", hostsTag}; !reflect.DeepEqual(servers, want) {
+		t.Errorf("servers %v, want %v", servers, want)
+	}
+	var got []string
+	for _, rule := range options.DNS.Rules {
+		got = append(got, rule.DefaultOptions.RouteOptions.Server)
+	}
+	// 静态解析、节点域名、自定义规则（域名和规则集各一条）、直连模式、国内域名
+	if want := []string{hostsTag, "localDns", "hkDns", "hkDns", "localDns", "localDns"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dns rules %v, want %v", got, want)
+	}
+	user := options.DNS.Rules[2].DefaultOptions
+	if user.RouteOptions.ClientSubnet == nil || user.RouteOptions.ClientSubnet.Build(netip.Prefix{}).String() != "203.0.113.0/24" {
+		t.Errorf("unexpected client subnet: %v", user.RouteOptions.ClientSubnet)
+	}
+}
+
+func TestTypedDNS(t *testing.T) {
+	peer := testPeer("a")
+	conf := testConfig(t, &config.Config{
+		PeerList: []*config.Peer{peer},
+		ProxyDNS: "https://dns.google/resolve",
+		LocalDNS: "dhcp://auto",
+		DNS: &config.DNSConfig{
+			Servers: []*config.DNSServer{{Tag: "dot", Address: "tls://dns.alidns.com", Detour: "direct"}},
+			Rules:   []*config.DNSRule{{Domains: []string{"ads.example.com"}, Server: "block"}},
+		},
+	})
+	options, err := buildOptions(peer, peer, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	proxyDNS := options.DNS.Servers[0]
+	https, ok := proxyDNS.Options.(*option.RemoteHTTPSDNSServerOptions)
+	if proxyDNS.Type != C.DNSTypeHTTPS || !ok {
+		t.Fatalf("unexpected proxyDns: %s %T", proxyDNS.Type, proxyDNS.Options)
+	}
+	if https.Server != "dns.google" || https.Path != "/resolve" || https.Detour != "proxy" {
+		t.Errorf("unexpected proxyDns options: %+v", https)
+	}
+	if localDNS := options.DNS.Servers[1]; localDNS.Type != C.DNSTypeDHCP {
+		t.Errorf("localDns type %s, want dhcp", localDNS.Type)
+	}
+	// 直连的域名服务器由 localDns 解析
+	dot, ok := options.DNS.Servers[2].Options.(*option.RemoteTLSDNSServerOptions)
+	if !ok || dot.DomainResolver == nil || dot.DomainResolver.Server != "localDns" || dot.Detour != "" {
+		t.Errorf("unexpected dot options: %+v", options.DNS.Servers[2].Options)
+	}
+	var blocked bool
+	for _, rule := range options.DNS.Rules {
+		if rule.DefaultOptions.Action == C.RuleActionTypePredefined {
+			blocked = reflect.DeepEqual(rule.DefaultOptions.DomainSuffix, badoption.Listable[string]{"ads.example.com"})
+		}
+	}
+	if !blocked {
+		t.Error("block rule not generated")
+	}
+
+	// 加载时保留的不合法地址在启动时报错
+	conf.LocalDNS = "tls://dns.alidns.com"
+	if _, err := buildOptions(peer, peer, conf, nil); err == nil {
+		t.Error("expected error for local_dns with domain")
+	}
+}
+
+func TestPeerSubnet(t *testing.T) {
+	a, b := testPeer("a"), testPeer("b")
+	conf := testConfig(t, &config.Config{PeerList: []*config.Peer{a, b}})
+	options, err := buildOptions(a, a, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if options.DNS.Final != "" || options.DNS.Servers[0].Tag != "proxyDns" {
+		t.Errorf("proxyDns wrapped without client subnet: %+v", options.DNS.Servers[0])
+	}
+
+	a.ClientSubnet = "203.0.113.0/24"
+	b.ClientSubnet = config.ClientSubnetAuto
+	options, err = buildOptions(a, a, conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	if options.DNS.Final != "proxyDns" || options.DNS.Servers[0].Tag != proxyDNSUpstream {
+		t.Fatalf("proxyDns not wrapped: final %s, servers %+v", options.DNS.Final, options.DNS.Servers)
+	}
+	server := options.DNS.Servers[len(options.DNS.Servers)-1]
+	opts := server.Options.(*PeerSubnetOptions)
+	if server.Type != peerSubnetType || opts.Server != proxyDNSUpstream || opts.Group != "proxy" {
+		t.Errorf("unexpected wrapper: %+v", server)
+	}
+	if want := map[string]string{peerTag("a"): "203.0.113.0/24", peerTag("b"): config.ClientSubnetAuto}; !reflect.DeepEqual(opts.Subnets, want) {
+		t.Errorf("subnets %v, want %v", opts.Subnets, want)
+	}
+}
+
+// stubOutbounds 只实现按 tag 查找出站
+type stubOutbounds struct {
+	adapter.OutboundManager
+	outbounds map[string]adapter.Outbound
+}
+
+func (m *stubOutbounds) Outbound(tag string) (adapter.Outbound, bool) {
+	out, loaded := m.outbounds[tag]
+	return out, loaded
+}
+
+// blockingDialer 拨号阻塞到 context 取消
+type blockingDialer struct {
+	started chan struct{}
+	done    atomic.Bool
+}
+
+func (d *blockingDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	close(d.started)
+	<-ctx.Done()
+	d.done.Store(true)
+	return nil, ctx.Err()
+}
+
+func (d *blockingDialer) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return nil, os.ErrInvalid
+}
+
+func TestPeerSubnetClose(t *testing.T) {
+	dialer := &blockingDialer{started: make(chan struct{})}
+	ctx := service.ContextWithDefaultRegistry(context.Background())
+	service.MustRegister[adapter.OutboundManager](ctx, &stubOutbounds{outbounds: map[string]adapter.Outbound{
+		"proxy": &testOutbound{Adapter: outbound.NewAdapter("test", "proxy", nil, nil), Dialer: dialer},
+	}})
+	transport, err := newPeerSubnet(ctx, log.NewNOPFactory().Logger(), "proxyDns", PeerSubnetOptions{
+		Group:   "proxy",
+		Subnets: map[string]string{"proxy": config.ClientSubnetAuto},
+	})
+	if err != nil {
+		t.Fatalf("newPeerSubnet failed: %v", err)
+	}
+	subnet := transport.(*peerSubnet)
+	subnet.current()
+	<-dialer.started
+	// Close 取消并等待进行中的出口 IP 探测
+	closed := make(chan struct{})
+	go func() {
+		_ = subnet.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not cancel egress detection")
+	}
+	// HTTP 客户端放弃的拨号也随 context 取消
+	for i := 0; !dialer.done.Load(); i++ {
+		if i == 100 {
+			t.Fatal("egress dial not cancelled by Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	subnet.retryAt = make(map[string]time.Time)
+	subnet.current()
+	if len(subnet.retryAt) != 0 {
+		t.Error("detection should not start after Close")
+	}
+}
+
+func TestDNSLog(t *testing.T) {
+	dnsLog := NewDNSLog()
+	start := time.Now()
+	for i := 0; i < dnsLogSize+10; i++ {
+		dnsLog.add(data.DNSQuery{
+			Time:   start.Add(time.Duration(i) * time.Millisecond),
+			Domain: fmt.Sprintf("%d.example.com", i),
+			Server: "proxyDns",
+		})
+	}
+	queries := dnsLog.Queries()
+	if len(queries) != dnsLogSize || queries[0].Domain != "10.example.com" || queries[len(queries)-1].Domain != fmt.Sprintf("%d.example.com", dnsLogSize+9) {
+		t.Fatalf("unexpected queries: %d, first %s", len(queries), queries[0].Domain)
+	}
+
+	dnsLog.add(data.DNSQuery{Time: start, Domain: "a.example.com", Server: "localDns", Direct: true, Leak: true})
+	dnsLog.add(data.DNSQuery{Time: start.Add(time.Second), Domain: "a.example.com", Server: "localDns", Direct: true, Leak: true})
+	dnsLog.add(data.DNSQuery{Time: start.Add(time.Second), Domain: "b.cn", Server: "localDns", Direct: true})
+	leaks := dnsLog.Leaks()
+	if len(leaks) != 1 || leaks[0].Domain != "a.example.com" || leaks[0].Count != 2 {
+		t.Errorf("unexpected leaks: %+v", leaks)
+	}
+}
+
+func TestLoggedRouter(t *testing.T) {
+	conf := testConfig(t, &config.Config{DNS: &config.DNSConfig{
+		Rules: []*config.DNSRule{{Domains: []string{"ads.example.com"}, Server: "block"}},
+	}})
+	options, err := buildOptions(testPeer("game"), testPeer("game"), conf, nil)
+	if err != nil {
+		t.Fatalf("buildOptions failed: %v", err)
+	}
+	var dnsLogOptions *DNSLogOptions
+	for _, out := range options.Outbounds {
+		if out.Type == dnsLogType {
+			dnsLogOptions = out.Options.(*DNSLogOptions)
+		}
+	}
+	if dnsLogOptions == nil {
+		t.Fatal("dns log outbound not found")
+	}
+	ctx := service.ContextWithDefaultRegistry(context.Background())
+	mode := newModeServer(ctx)
+	service.MustRegister[adapter.ClashServer](ctx, mode)
+	dnsLog := NewDNSLog()
+	out, err := newDNSLogOutbound(service.ContextWithPtr(ctx, dnsLog), nil, log.NewNOPFactory().Logger(), "dns_out", *dnsLogOptions)
+	if err != nil {
+		t.Fatalf("newDNSLogOutbound failed: %v", err)
+	}
+	dnsOut := out.(*dnsLogOutbound)
+	if err := dnsOut.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer dnsOut.Close()
+	router := dnsOut.router
+	local := &tracedTransport{DNSTransport: &stubTransport{tag: "localDns"}}
+	exchange := func(domain string, transport adapter.DNSTransport) data.DNSQuery {
+		router.DNSRouter = &stubRouter{transport: transport}
+		message := new(dns.Msg)
+		message.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+		if _, err := router.Exchange(context.Background(), message, adapter.DNSQueryOptions{}); err != nil {
+			t.Fatalf("Exchange %s failed: %v", domain, err)
+		}
+		queries := dnsLog.Queries()
+		return queries[len(queries)-1]
+	}
+
+	if query := exchange("www.example.com", nil); query.Source != data.DNSSourceCache {
+		t.Errorf("expected cache answer, got %+v", query)
+	}
+	if query := exchange("ads.example.com", nil); query.Source != data.DNSSourceRule {
+		t.Errorf("expected rule answer, got %+v", query)
+	}
+	// 国内域名由规则有意直连
+	if query := exchange("a.cn", local); query.Source != data.DNSSourceServer || !query.Direct || query.Leak {
+		t.Errorf("expected intended direct query, got %+v", query)
+	}
+	if query := exchange("www.example.com", local); !query.Leak {
+		t.Errorf("expected leak, got %+v", query)
+	}
+	mode.SetMode(config.ModeDirect)
+	if query := exchange("www.example.com", local); query.Leak {
+		t.Errorf("direct mode query should not leak, got %+v", query)
+	}
+	leaks := dnsLog.Leaks()
+	if len(leaks) != 1 || leaks[0].Domain != "www.example.com" || leaks[0].Count != 1 {
+		t.Errorf("unexpected leaks: %+v", leaks)
+	}
+}
+
+// stubRouter 经指定服务器查询，未指定时直接应答
+type stubRouter struct {
+	adapter.DNSRouter
+	transport adapter.DNSTransport
+}
+
+func (s *stubRouter) Exchange(ctx context.Context, message *dns.Msg, options adapter.DNSQueryOptions) (*dns.Msg, error) {
+	if s.transport != nil {
+		return s.transport.Exchange(ctx, message)
+	}
+	return message, nil
+}
+
+func TestTracedTransport(t *testing.T) {
+	trace := new(dnsTrace)
+	ctx := context.WithValue(context.Background(), dnsTraceKey{}, trace)
+	upstream := &tracedTransport{DNSTransport: &stubTransport{tag: "proxyDnsUpstream"}}
+	outer := &tracedTransport{DNSTransport: &stubTransport{tag: "proxyDns", next: upstream}}
+	if _, err := outer.Exchange(ctx, new(dns.Msg)); err != nil {
+		t.Fatal(err)
+	}
+	// 包装的服务器记录最外层
+	if server := trace.server.Load(); server == nil || *server != "proxyDns" {
+		t.Errorf("unexpected server: %v", server)
+	}
+}
+
+type stubTransport struct {
+	adapter.DNSTransport
+	tag  string
+	next adapter.DNSTransport
+}
+
+func (s *stubTransport) Tag() string {
+	return s.tag
+}
+
+func (s *stubTransport) Exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	if s.next != nil {
+		return s.next.Exchange(ctx, message)
+	}
+	return message, nil
+}
+
+func TestRateSampler(t *testing.T) {
+	tracker := NewTracker()
+	rateHistory := 5 * time.Minute
+	sampler := newRateSampler(tracker, rateHistory)
+	start := time.Now()
+	sampler.sample(start)
+	game := tracker.traffic["game"]
+	for i := 1; i <= int(rateHistory/rateInterval)+2; i++ {
+		game.up.Add(1000)
+		game.down.Add(int64(2000 * i))
+		sampler.sample(start.Add(time.Duration(i) * rateInterval))
+	}
+	rates := sampler.Rates()
+	if len(rates.History) != int(rateHistory/rateInterval) || !rates.History[0].Time.Equal(start.Add(3*rateInterval)) {
+		t.Fatalf("unexpected history: %d, first %v", len(rates.History), rates.History[0].Time)
+	}
+	last := len(rates.History) + 2
+	if rates.Current[0].Outbound != "game" || rates.Current[0].Up != 1000 || rates.Current[0].Down != uint64(2000*last) {
+		t.Errorf("unexpected current: %+v", rates.Current)
+	}
+	// 速率下降后峰值保持
+	sampler.sample(start.Add(time.Duration(last+1) * rateInterval))
+	rates = sampler.Rates()
+	if rates.Current[0].Down != 0 || rates.Peak[0].Down != uint64(2000*last) {
+		t.Errorf("unexpected current %+v, peak %+v", rates.Current, rates.Peak)
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danbai225/gpp/backend/config"
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/common/urltest"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+)
+
+// chainOptions 生成只包含代理链节点的 sing-box 配置
+func chainOptions(peer *config.Peer, list []*config.Peer) (option.Options, error) {
+	chain, err := config.DetourChain(peer, list)
+	if err != nil {
+		return option.Options{}, err
+	}
+	outbounds := make([]option.Outbound, len(chain))
+	for i, p := range chain {
+		outbounds[i] = getOUt(p)
+		outbounds[i].Tag = peerTag(p.Name)
+	}
+	return option.Options{
+		Log:       &option.LogOptions{Disabled: true},
+		Outbounds: outbounds,
+	}, nil
+}
+
+// PingChain 通过完整的代理链请求探测地址，返回延迟毫秒数
+func PingChain(peer *config.Peer, list []*config.Peer) (uint, error) {
+	options, err := chainOptions(peer, list)
+	if err != nil {
+		return 0, err
+	}
+	ctx := newContext()
+	instance, err := box.New(box.Options{
+		Context: ctx,
+		Options: options,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = instance.Close() }()
+	if err = instance.Start(); err != nil {
+		return 0, err
+	}
+	out, loaded := instance.Outbound().Outbound(peerTag(peer.Name))
+	if !loaded {
+		return 0, fmt.Errorf("outbound not found: %s", peer.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, C.TCPTimeout)
+	defer cancel()
+	delay, err := urltest.URLTest(ctx, config.DefaultProbeURL, out)
+	if err != nil {
+		return 0, err
+	}
+	return uint(delay), nil
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+
+	"github.com/danbai225/gpp/backend/config"
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common"
+	"github.com/sagernet/sing/common/json/badjson"
+	"github.com/sagernet/sing/common/json/badoption"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	hostsTag = "hosts"
+	// checkDomain 检查 DNS 服务器可用性时查询的域名
+	checkDomain = "www.gstatic.com"
+)
+
+// addressQueryTypes A、AAAA 查询
+var addressQueryTypes = badoption.Listable[option.DNSQueryType]{
+	option.DNSQueryType(1),  // A
+	option.DNSQueryType(28), // AAAA
+}
+
+// dnsServer 根据解析后的地址生成 DNS 服务器，detour 为经过的出站
+// 经代理的服务器由节点解析域名，直连的服务器使用 IP 地址的 localDns 解析域名
+func dnsServer(tag string, address *config.DNSAddress, detour string) option.DNSServerOptions {
+	var dialer option.DialerOptions
+	if detour != "direct" {
+		dialer.Detour = detour
+	} else if _, err := netip.ParseAddr(address.Host); address.IsRemote() && err != nil {
+		dialer.DomainResolver = &option.DomainResolveOptions{Server: "localDns"}
+	}
+	local := option.LocalDNSServerOptions{DialerOptions: dialer}
+	remote := option.RemoteDNSServerOptions{
+		LocalDNSServerOptions: local,
+		DNSServerAddressOptions: option.DNSServerAddressOptions{
+			Server:     address.Host,
+			ServerPort: address.Port,
+		},
+	}
+	server := option.DNSServerOptions{Type: address.Type, Tag: tag}
+	switch address.Type {
+	case C.DNSTypeLocal:
+		server.Options = &local
+	case C.DNSTypeDHCP:
+		server.Options = &option.DHCPDNSServerOptions{LocalDNSServerOptions: local, Interface: address.Interface}
+	case C.DNSTypeTLS, C.DNSTypeQUIC:
+		server.Options = &option.RemoteTLSDNSServerOptions{RemoteDNSServerOptions: remote}
+	case C.DNSTypeHTTPS, C.DNSTypeHTTP3:
+		server.Options = &option.RemoteHTTPSDNSServerOptions{
+			RemoteTLSDNSServerOptions: option.RemoteTLSDNSServerOptions{RemoteDNSServerOptions: remote},
+			Path:                      address.Path,
+		}
+	default:
+		server.Options = &remote
+	}
+	return server
+}
+
+// userDNSServers 生成自定义 DNS 服务器，配置静态解析时追加 hosts 服务器，地址已由配置校验
+func userDNSServers(dnsConf *config.DNSConfig) []option.DNSServerOptions {
+	servers := make([]option.DNSServerOptions, 0, len(dnsConf.Servers)+1)
+	for _, server := range dnsConf.Servers {
+		address, _ := config.ParseDNSAddress(server.Address)
+		servers = append(servers, dnsServer(server.Tag, address, server.Detour))
+	}
+	if len(dnsConf.Hosts) > 0 {
+		predefined := new(badjson.TypedMap[string, badoption.Listable[netip.Addr]])
+		for _, domain := range hostDomains(dnsConf) {
+			addrs := make(badoption.Listable[netip.Addr], 0, len(dnsConf.Hosts[domain]))
+			for _, addr := range dnsConf.Hosts[domain] {
+				addrs = append(addrs, netip.MustParseAddr(addr))
+			}
+			predefined.Put(domain, addrs)
+		}
+		servers = append(servers, option.DNSServerOptions{
+			Type:    C.DNSTypeHosts,
+			Tag:     hostsTag,
+			Options: &option.HostsDNSServerOptions{Predefined: predefined},
+		})
+	}
+	return servers
+}
+
+// directDNSServers 返回不经过代理查询的 DNS 服务器
+func directDNSServers(conf *config.Config) []string {
+	servers := []string{"localDns"}
+	if conf.DNS != nil {
+		for _, server := range conf.DNS.Servers {
+			if server.Detour == "direct" {
+				servers = append(servers, server.Tag)
+			}
+		}
+	}
+	return servers
+}
+
+// hostsRules 静态解析的域名的 A、AAAA 查询使用 hosts 服务器
+func hostsRules(dnsConf *config.DNSConfig) []option.DNSRule {
+	if len(dnsConf.Hosts) == 0 {
+		return nil
+	}
+	return []option.DNSRule{dnsRouteRule(option.RawDefaultDNSRule{
+		Domain:    hostDomains(dnsConf),
+		QueryType: addressQueryTypes,
+	}, hostsTag)}
+}
+
+// userDNSRules 生成自定义 DNS 规则，同一规则内的域名和规则集条件为与关系，分开生成
+func userDNSRules(dnsConf *config.DNSConfig) []option.DNSRule {
+	var rules []option.DNSRule
+	for _, rule := range dnsConf.Rules {
+		var matchers []option.RawDefaultDNSRule
+		if len(rule.Domains) > 0 {
+			matchers = append(matchers, option.RawDefaultDNSRule{DomainSuffix: rule.Domains})
+		}
+		if len(rule.RuleSets) > 0 {
+			matchers = append(matchers, option.RawDefaultDNSRule{RuleSet: rule.RuleSets})
+		}
+		for _, matcher := range matchers {
+			if rule.Server == "block" {
+				rules = append(rules, dnsBlockRule(matcher))
+				continue
+			}
+			dnsRule := dnsRouteRule(matcher, rule.Server)
+			if rule.ClientSubnet != "" {
+				// 已由配置校验
+				prefix, _ := config.ParseClientSubnet(rule.ClientSubnet)
+				dnsRule.DefaultOptions.RouteOptions.ClientSubnet = (*badoption.Prefixable)(&prefix)
+			}
+			rules = append(rules, dnsRule)
+		}
+	}
+	return rules
+}
+
+// hostDomains 返回排序后的静态解析域名，保证生成的配置稳定
+func hostDomains(dnsConf *config.DNSConfig) []string {
+	domains := make([]string, 0, len(dnsConf.Hosts))
+	for domain := range dnsConf.Hosts {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// dnsBlockRule 返回不含地址的成功响应
+func dnsBlockRule(matcher option.RawDefaultDNSRule) option.DNSRule {
+	return option.DNSRule{
+		Type: C.RuleTypeDefault,
+		DefaultOptions: option.DefaultDNSRule{
+			RawDefaultDNSRule: matcher,
+			DNSRuleAction: option.DNSRuleAction{
+				Action: C.RuleActionTypePredefined,
+				PredefinedOptions: option.DNSRouteActionPredefined{
+					Rcode: common.Ptr(option.DNSRCode(0)), // NOERROR
+				},
+			},
+		},
+	}
+}
+
+// dnsRouteRule 生成路由到指定 DNS 服务器的规则
+func dnsRouteRule(matcher option.RawDefaultDNSRule, server string) option.DNSRule {
+	return option.DNSRule{
+		Type: C.RuleTypeDefault,
+		DefaultOptions: option.DefaultDNSRule{
+			RawDefaultDNSRule: matcher,
+			DNSRuleAction: option.DNSRuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.DNSRouteActionOptions{
+					Server: server,
+				},
+			},
+		},
+	}
+}
+
+// CheckDNS 查询测试域名检查 DNS 服务器是否可用，peer 不为空时经过该节点查询
+// 直连查询的远程服务器地址必须为 IP
+func CheckDNS(address string, peer *config.Peer, list []*config.Peer) error {
+	parsed, err := config.ParseDNSAddress(address)
+	if err != nil {
+		return err
+	}
+	options := option.Options{Log: &option.LogOptions{Disabled: true}}
+	detour := "direct"
+	if peer != nil && peer.Protocol != "direct" {
+		options, err = chainOptions(peer, list)
+		if err != nil {
+			return err
+		}
+		detour = peerTag(peer.Name)
+	}
+	// 节点域名使用系统 DNS 解析，避免经过被检查的服务器
+	options.DNS = &option.DNSOptions{
+		RawDNSOptions: option.RawDNSOptions{
+			Servers: []option.DNSServerOptions{
+				dnsServer("check", parsed, detour),
+				{Type: C.DNSTypeLocal, Tag: "system", Options: &option.LocalDNSServerOptions{}},
+			},
+			Final: "check",
+		},
+	}
+	options.Route = &option.RouteOptions{
+		DefaultDomainResolver: &option.DomainResolveOptions{Server: "system"},
+	}
+	ctx := newContext()
+	instance, err := box.New(box.Options{
+		Context: ctx,
+		Options: options,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = instance.Close() }()
+	if err = instance.Start(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, C.DNSTimeout)
+	defer cancel()
+	_, err = service.FromContext[adapter.DNSRouter](ctx).Lookup(ctx, checkDomain, adapter.DNSQueryOptions{DisableCache: true})
+	return err
+}
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbai225/gpp/backend/data"
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/adapter/outbound"
+	C "github.com/sagernet/sing-box/constant"
+	dnsutil "github.com/sagernet/sing-box/dns"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	dnsOutbound "github.com/sagernet/sing-box/protocol/dns"
+	R "github.com/sagernet/sing-box/route/rule"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	dnsLogType = "dns_log"
+	// dnsLogSize DNS 日志和泄露汇总保留的最大条数
+	dnsLogSize = 1000
+)
+
+// DNSLogOptions 记录查询的 DNS 出站参数
+type DNSLogOptions struct {
+	// Direct 不经过代理查询的 DNS 服务器
+	Direct []string `json:"direct,omitempty"`
+	// Rules 与 DNS 路由相同的规则，用于区分规则应答和规则有意直连的查询
+	Rules []option.DNSRule `json:"rules,omitempty"`
+}
+
+func registerDNSLog(registry *outbound.Registry) {
+	outbound.Register[DNSLogOptions](registry, dnsLogType, newDNSLogOutbound)
+}
+
+// DNSLog 保存最近的 DNS 查询，并汇总本次加速期间泄露的查询
+type DNSLog struct {
+	access  sync.Mutex
+	queries []data.DNSQuery
+	next    int
+	leaks   map[string]*data.DNSLeak
+}
+
+// NewDNSLog 创建 DNS 日志
+func NewDNSLog() *DNSLog {
+	return &DNSLog{
+		queries: make([]data.DNSQuery, 0, dnsLogSize),
+		leaks:   make(map[string]*data.DNSLeak),
+	}
+}
+
+func (l *DNSLog) add(query data.DNSQuery) {
+	l.access.Lock()
+	defer l.access.Unlock()
+	if len(l.queries) < dnsLogSize {
+		l.queries = append(l.queries, query)
+	} else {
+		l.queries[l.next] = query
+		l.next = (l.next + 1) % dnsLogSize
+	}
+	if !query.Leak {
+		return
+	}
+	key := query.Server + " " + query.Domain
+	leak, loaded := l.leaks[key]
+	if !loaded {
+		if len(l.leaks) >= dnsLogSize {
+			return
+		}
+		leak = &data.DNSLeak{Domain: query.Domain, Server: query.Server}
+		l.leaks[key] = leak
+	}
+	leak.Count++
+	leak.Last = query.Time
+}
+
+// Queries 返回按时间排序的最近查询
+func (l *DNSLog) Queries() []data.DNSQuery {
+	l.access.Lock()
+	defer l.access.Unlock()
+	list := make([]data.DNSQuery, 0, len(l.queries))
+	list = append(list, l.queries[l.next:]...)
+	return append(list, l.queries[:l.next]...)
+}
+
+// Leaks 返回未按规则经直连服务器查询的域名，按最近查询时间倒序
+func (l *DNSLog) Leaks() []data.DNSLeak {
+	l.access.Lock()
+	defer l.access.Unlock()
+	list := make([]data.DNSLeak, 0, len(l.leaks))
+	for _, leak := range l.leaks {
+		list = append(list, *leak)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Last.After(list[j].Last)
+	})
+	return list
+}
+
+// Export 将最近查询以 JSON 格式写入文件
+func (l *DNSLog) Export(path string) error {
+	content, err := json.MarshalIndent(l.Queries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// dnsLogOut 生成劫持 DNS 请求的出站，direct 为不经过代理的服务器，rules 为 DNS 规则
+func dnsLogOut(direct []string, rules []option.DNSRule) option.Outbound {
+	return option.Outbound{
+		Type:    dnsLogType,
+		Tag:     "dns_out",
+		Options: &DNSLogOptions{Direct: direct, Rules: rules},
+	}
+}
+
+// dnsLogOutbound 与内置 DNS 出站相同，查询经过 loggedRouter 记录
+type dnsLogOutbound struct {
+	outbound.Adapter
+	router *loggedRouter
+}
+
+func newDNSLogOutbound(ctx context.Context, router adapter.Router, logger log.ContextLogger, tag string, options DNSLogOptions) (adapter.Outbound, error) {
+	dnsLog := service.PtrFromContext[DNSLog](ctx)
+	if dnsLog == nil {
+		dnsLog = NewDNSLog()
+	}
+	direct := make(map[string]bool, len(options.Direct))
+	for _, server := range options.Direct {
+		direct[server] = true
+	}
+	rules := make([]adapter.DNSRule, 0, len(options.Rules))
+	for i, ruleOptions := range options.Rules {
+		rule, err := R.NewDNSRule(ctx, logger, ruleOptions, false)
+		if err != nil {
+			return nil, E.Cause(err, "parse dns rule[", i, "]")
+		}
+		rules = append(rules, rule)
+	}
+	return &dnsLogOutbound{
+		Adapter: outbound.NewAdapter(dnsLogType, tag, []string{N.NetworkTCP, N.NetworkUDP}, nil),
+		router: &loggedRouter{
+			DNSRouter: service.FromContext[adapter.DNSRouter](ctx),
+			log:       dnsLog,
+			direct:    direct,
+			rules:     rules,
+		},
+	}, nil
+}
+
+// Start 规则集在路由初始化时已创建
+func (d *dnsLogOutbound) Start() error {
+	for i, rule := range d.router.rules {
+		if err := rule.Start(); err != nil {
+			return E.Cause(err, "initialize dns rule[", i, "]")
+		}
+	}
+	return nil
+}
+
+func (d *dnsLogOutbound) Close() error {
+	for _, rule := range d.router.rules {
+		_ = rule.Close()
+	}
+	return nil
+}
+
+func (d *dnsLogOutbound) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return nil, os.ErrInvalid
+}
+
+func (d *dnsLogOutbound) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	return nil, os.ErrInvalid
+}
+
+func (d *dnsLogOutbound) NewConnectionEx(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, onClose N.CloseHandlerFunc) {
+	metadata.Destination = M.Socksaddr{}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(C.DNSTimeout))
+		err := dnsOutbound.HandleStreamDNSRequest(ctx, d.router, conn, metadata)
+		if err != nil {
+			_ = conn.Close()
+			if onClose != nil {
+				onClose(err)
+			}
+			return
+		}
+	}
+}
+
+func (d *dnsLogOutbound) NewPacketConnectionEx(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, onClose N.CloseHandlerFunc) {
+	_ = dnsOutbound.NewDNSPacketConnection(ctx, d.router, conn, nil, metadata)
+}
+
+// loggedRouter 记录每次查询的结果，实际使用的服务器由 tracedTransport 写入 context
+type loggedRouter struct {
+	adapter.DNSRouter
+	log    *DNSLog
+	direct map[string]bool
+	rules  []adapter.DNSRule
+}
+
+func (r *loggedRouter) Exchange(ctx context.Context, message *dns.Msg, options adapter.DNSQueryOptions) (*dns.Msg, error) {
+	if len(message.Question) != 1 {
+		return r.DNSRouter.Exchange(ctx, message, options)
+	}
+	trace := new(dnsTrace)
+	start := time.Now()
+	response, err := r.DNSRouter.Exchange(context.WithValue(ctx, dnsTraceKey{}, trace), message, options)
+	question := message.Question[0]
+	query := data.DNSQuery{
+		Time:    start,
+		Domain:  dnsutil.FqdnToDomain(question.Name),
+		Type:    dns.TypeToString[question.Qtype],
+		Latency: uint(time.Since(start).Milliseconds()),
+	}
+	if server := trace.server.Load(); server != nil {
+		query.Source = data.DNSSourceServer
+		query.Server = *server
+		query.Direct = r.direct[query.Server]
+		// 直连模式、国内域名、节点域名和自定义规则有意发往直连服务器的查询不算泄露
+		query.Leak = query.Direct && r.match(ctx, question, query.Server) == nil
+	} else {
+		// 未查询任何服务器，命中拒绝或预定义规则时为规则应答，否则为缓存
+		switch rule := r.match(ctx, question, ""); {
+		case rule != nil && rule.Action().Type() != C.RuleActionTypeRoute:
+			query.Source = data.DNSSourceRule
+		case err == nil:
+			query.Source = data.DNSSourceCache
+		}
+	}
+	if err != nil {
+		query.Error = err.Error()
+	} else if response != nil {
+		for _, record := range response.Answer {
+			query.Answer = append(query.Answer, strings.TrimPrefix(record.String(), record.Header().String()))
+		}
+	}
+	r.log.add(query)
+	return response, err
+}
+
+// match 按 DNS 路由的顺序匹配规则，server 为空时返回第一条决定应答的规则，
+// 否则返回第一条路由到 server 的规则，未命中返回 nil
+func (r *loggedRouter) match(ctx context.Context, question dns.Question, server string) adapter.DNSRule {
+	var metadata adapter.InboundContext
+	if inbound := adapter.ContextFrom(ctx); inbound != nil {
+		metadata = *inbound
+	}
+	metadata.Destination = M.Socksaddr{}
+	metadata.QueryType = question.Qtype
+	switch question.Qtype {
+	case dns.TypeA:
+		metadata.IPVersion = 4
+	case dns.TypeAAAA:
+		metadata.IPVersion = 6
+	}
+	metadata.Domain = dnsutil.FqdnToDomain(question.Name)
+	isAddressQuery := question.Qtype == dns.TypeA || question.Qtype == dns.TypeAAAA || question.Qtype == dns.TypeHTTPS
+	for _, rule := range r.rules {
+		if rule.WithAddressLimit() && !isAddressQuery {
+			continue
+		}
+		metadata.ResetRuleCache()
+		if !rule.Match(&metadata) {
+			continue
+		}
+		switch action := rule.Action().(type) {
+		case *R.RuleActionDNSRoute:
+			// 地址限制的规则不匹配应答时继续匹配后续规则
+			if server == "" || action.Server == server {
+				return rule
+			}
+		case *R.RuleActionReject, *R.RuleActionPredefined:
+			if server == "" {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+type dnsTraceKey struct{}
+
+// dnsTrace 记录查询实际使用的服务器，包装的服务器只保留最外层
+type dnsTrace struct {
+	server atomic.Pointer[string]
+}
+
+// tracedRegistry 创建的 DNS 服务器在查询时写入 dnsTrace
+type tracedRegistry struct {
+	*dnsutil.TransportRegistry
+}
+
+func (r *tracedRegistry) CreateDNSTransport(ctx context.Context, logger log.ContextLogger, tag string, transportType string, options any) (adapter.DNSTransport, error) {
+	transport, err := r.TransportRegistry.CreateDNSTransport(ctx, logger, tag, transportType, options)
+	if err != nil {
+		return nil, err
+	}
+	traced := &tracedTransport{DNSTransport: transport}
+	// FakeIP 服务器需保留地址池接口
+	if fakeIP, isFakeIP := transport.(adapter.FakeIPTransport); isFakeIP {
+		return &tracedFakeIP{tracedTransport: traced, fakeIP: fakeIP}, nil
+	}
+	return traced, nil
+}
+
+type tracedTransport struct {
+	adapter.DNSTransport
+}
+
+func (t *tracedTransport) Exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	if trace, loaded := ctx.Value(dnsTraceKey{}).(*dnsTrace); loaded {
+		tag := t.Tag()
+		trace.server.CompareAndSwap(nil, &tag)
+	}
+	return t.DNSTransport.Exchange(ctx, message)
+}
+
+type tracedFakeIP struct {
+	*tracedTransport
+	fakeIP adapter.FakeIPTransport
+}
+
+func (t *tracedFakeIP) Store() adapter.FakeIPStore {
+	return t.fakeIP.Store()
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/urltest"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+const failoverTag = "failover"
+
+// failoverOut 生成游戏流量的故障转移节点组
+// urltest 直接使用 sing-box 节点组，fallback 为选择器，由 fallbackWatcher 按顺序探测后切换
+func failoverOut(failover *config.Failover) option.Outbound {
+	tags := make([]string, len(failover.Peers))
+	for i, name := range failover.Peers {
+		tags[i] = peerTag(name)
+	}
+	if failover.Type == config.FailoverFallback {
+		return selectorOut(failoverTag, tags, tags[0])
+	}
+	return option.Outbound{
+		Type: C.TypeURLTest,
+		Tag:  failoverTag,
+		Options: &option.URLTestOutboundOptions{
+			Outbounds:                 tags,
+			URL:                       failover.URL,
+			Interval:                  badoption.Duration(time.Duration(failover.Interval) * time.Second),
+			Tolerance:                 failover.Tolerance,
+			InterruptExistConnections: true,
+		},
+	}
+}
+
+// fallbackWatcher 定时按顺序探测节点，选择第一个可用节点
+type fallbackWatcher struct {
+	outbound adapter.OutboundManager
+	tags     []string
+	url      string
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     sync.WaitGroup
+}
+
+func newFallbackWatcher(outbound adapter.OutboundManager, failover *config.Failover) *fallbackWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	tags := make([]string, len(failover.Peers))
+	for i, name := range failover.Peers {
+		tags[i] = peerTag(name)
+	}
+	return &fallbackWatcher{
+		outbound: outbound,
+		tags:     tags,
+		url:      failover.URL,
+		interval: time.Duration(failover.Interval) * time.Second,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (w *fallbackWatcher) Start() {
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			w.check()
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *fallbackWatcher) Close() {
+	w.cancel()
+	w.done.Wait()
+}
+
+// check 选择第一个探测成功的节点，全部失败时保持当前节点
+func (w *fallbackWatcher) check() {
+	group, loaded := w.outbound.Outbound(failoverTag)
+	if !loaded {
+		return
+	}
+	selector, ok := group.(interface{ SelectOutbound(string) bool })
+	if !ok {
+		return
+	}
+	for _, tag := range w.tags {
+		detour, loaded := w.outbound.Outbound(tag)
+		if !loaded {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(w.ctx, C.TCPTimeout)
+		_, err := urltest.URLTest(ctx, w.url, detour)
+		cancel()
+		if err == nil {
+			selector.SelectOutbound(tag)
+			return
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+	}
+}
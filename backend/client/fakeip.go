@@ -0,0 +1,46 @@
+package client
+
+import (
+	"net/netip"
+
+	"github.com/danbai225/gpp/backend/config"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+const fakeIPTag = "fakeip"
+
+// fakeIPServer 生成 FakeIP DNS 服务器，地址段已由配置校验
+func fakeIPServer(fakeIP *config.FakeIPConfig) option.DNSServerOptions {
+	inet4Range := badoption.Prefix(netip.MustParsePrefix(fakeIP.Inet4Range))
+	inet6Range := badoption.Prefix(netip.MustParsePrefix(fakeIP.Inet6Range))
+	return option.DNSServerOptions{
+		Type: C.DNSTypeFakeIP,
+		Tag:  fakeIPTag,
+		Options: &option.FakeIPDNSServerOptions{
+			Inet4Range: &inet4Range,
+			Inet6Range: &inet6Range,
+		},
+	}
+}
+
+// fakeIPRules 排除的域名和规则集走代理 DNS 获取真实地址，其余 A、AAAA 查询返回虚假地址
+func fakeIPRules(fakeIP *config.FakeIPConfig, strategy option.DomainStrategy) []option.DNSRule {
+	// 同一规则内的域名和规则集条件为与关系，分开生成
+	var rules []option.DNSRule
+	if len(fakeIP.ExcludeDomains) > 0 {
+		rules = append(rules, dnsRouteRule(option.RawDefaultDNSRule{
+			DomainSuffix: fakeIP.ExcludeDomains,
+		}, "proxyDns"))
+	}
+	if len(fakeIP.ExcludeRuleSets) > 0 {
+		rules = append(rules, dnsRouteRule(option.RawDefaultDNSRule{
+			RuleSet: fakeIP.ExcludeRuleSets,
+		}, "proxyDns"))
+	}
+	rule := dnsRouteRule(option.RawDefaultDNSRule{QueryType: addressQueryTypes}, fakeIPTag)
+	// FakeIP 服务器没有解析策略，由规则指定
+	rule.DefaultOptions.RouteOptions.Strategy = strategy
+	return append(rules, rule)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/adapter/outbound"
+	"github.com/sagernet/sing-box/common/urltest"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json/badoption"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	killSwitchType = "kill_switch"
+	killSwitchTag  = "kill_switch"
+)
+
+// KillSwitchOptions 断网保护出站参数
+type KillSwitchOptions struct {
+	// Outbound 受保护的出站，通常为游戏选择器
+	Outbound string             `json:"outbound"`
+	URL      string             `json:"url"`
+	Interval badoption.Duration `json:"interval,omitempty"`
+}
+
+func registerKillSwitch(registry *outbound.Registry) {
+	outbound.Register[KillSwitchOptions](registry, killSwitchType, newKillSwitch)
+}
+
+// killSwitchOut 生成包装游戏选择器的断网保护出站
+func killSwitchOut(killSwitch *config.KillSwitch) option.Outbound {
+	return option.Outbound{
+		Type: killSwitchType,
+		Tag:  killSwitchTag,
+		Options: &KillSwitchOptions{
+			Outbound: "proxy",
+			URL:      killSwitch.URL,
+			Interval: badoption.Duration(time.Duration(killSwitch.Interval) * time.Second),
+		},
+	}
+}
+
+// applyKillSwitch 将路由到游戏选择器的规则和默认出站改为断网保护出站，DNS 仍经过游戏选择器
+func applyKillSwitch(options *option.Options, killSwitch *config.KillSwitch) {
+	options.Outbounds = append(options.Outbounds, killSwitchOut(killSwitch))
+	for i := range options.Route.Rules {
+		rule := &options.Route.Rules[i]
+		action := &rule.DefaultOptions.RuleAction
+		if rule.Type == C.RuleTypeLogical {
+			action = &rule.LogicalOptions.RuleAction
+		}
+		if action.Action == C.RuleActionTypeRoute && action.RouteOptions.Outbound == "proxy" {
+			action.RouteOptions.Outbound = killSwitchTag
+		}
+	}
+	options.Route.Final = killSwitchTag
+}
+
+// killSwitch 游戏节点探测失败或实际使用直连时拒绝新连接，避免游戏流量从本机直接发出
+type killSwitch struct {
+	outbound.Adapter
+	ctx      context.Context
+	outbound adapter.OutboundManager
+	logger   log.ContextLogger
+	tag      string
+	url      string
+	interval time.Duration
+	proxy    adapter.Outbound
+	blocked  atomic.Bool
+	checking atomic.Bool
+	access   sync.Mutex
+	cancel   context.CancelFunc
+	done     sync.WaitGroup
+}
+
+func newKillSwitch(ctx context.Context, router adapter.Router, logger log.ContextLogger, tag string, options KillSwitchOptions) (adapter.Outbound, error) {
+	if options.Outbound == "" {
+		return nil, E.New("missing outbound")
+	}
+	interval := time.Duration(options.Interval)
+	if interval == 0 {
+		interval = C.DefaultURLTestInterval
+	}
+	// 关闭时取消定时探测和进行中的重新探测
+	ctx, cancel := context.WithCancel(ctx)
+	return &killSwitch{
+		Adapter:  outbound.NewAdapter(killSwitchType, tag, []string{N.NetworkTCP, N.NetworkUDP}, []string{options.Outbound}),
+		ctx:      ctx,
+		cancel:   cancel,
+		outbound: service.FromContext[adapter.OutboundManager](ctx),
+		logger:   logger,
+		tag:      options.Outbound,
+		url:      options.URL,
+		interval: interval,
+	}, nil
+}
+
+func (k *killSwitch) Start() error {
+	proxy, loaded := k.outbound.Outbound(k.tag)
+	if !loaded {
+		return E.New("outbound not found: ", k.tag)
+	}
+	k.proxy = proxy
+	return nil
+}
+
+func (k *killSwitch) PostStart() error {
+	k.done.Add(1)
+	go func() {
+		defer k.done.Done()
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+		for {
+			k.check()
+			select {
+			case <-k.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Close 取消并等待定时探测和进行中的重新探测
+func (k *killSwitch) Close() error {
+	k.access.Lock()
+	k.cancel()
+	k.access.Unlock()
+	k.done.Wait()
+	return nil
+}
+
+// Blocked 游戏流量是否正被拦截
+func (k *killSwitch) Blocked() bool {
+	return k.blocked.Load()
+}
+
+// check 探测当前实际使用的节点，直连出站视为不可用
+func (k *killSwitch) check() {
+	tag := activeOutbound(k.outbound, k.tag)
+	out, loaded := k.outbound.Outbound(tag)
+	err := E.New("outbound not found: ", tag)
+	if loaded && out.Type() == C.TypeDirect {
+		err = E.New(tag, " is direct")
+	} else if loaded {
+		ctx, cancel := context.WithTimeout(k.ctx, C.TCPTimeout)
+		_, err = urltest.URLTest(ctx, k.url, out)
+		cancel()
+	}
+	if k.ctx.Err() != nil {
+		return
+	}
+	if err != nil && !k.blocked.Swap(true) {
+		k.logger.Warn("kill switch on, blocking game traffic: ", err)
+	} else if err == nil && k.blocked.Swap(false) {
+		k.logger.Info("kill switch off, ", tag, " is available")
+	}
+}
+
+// recheck 连接失败或被拦截时立即重新探测，同一时间只有一次探测
+func (k *killSwitch) recheck() {
+	k.access.Lock()
+	defer k.access.Unlock()
+	if k.ctx.Err() != nil || !k.checking.CompareAndSwap(false, true) {
+		return
+	}
+	k.done.Add(1)
+	go func() {
+		defer k.done.Done()
+		defer k.checking.Store(false)
+		k.check()
+	}()
+}
+
+func (k *killSwitch) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	if k.blocked.Load() {
+		k.recheck()
+		return nil, E.New("kill switch: game peer unavailable")
+	}
+	conn, err := k.proxy.DialContext(ctx, network, destination)
+	if err != nil {
+		k.recheck()
+	}
+	return conn, err
+}
+
+func (k *killSwitch) ListenPacket(ctx context.Context, destination M.Socksaddr) (net.PacketConn, error) {
+	if k.blocked.Load() {
+		k.recheck()
+		return nil, E.New("kill switch: game peer unavailable")
+	}
+	conn, err := k.proxy.ListenPacket(ctx, destination)
+	if err != nil {
+		k.recheck()
+	}
+	return conn, err
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/common/urltest"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+// modeServer 未配置 Clash API 时为 clash_mode 规则提供路由模式，不监听端口，也不依赖 with_clash_api 标签
+type modeServer struct {
+	ctx     context.Context
+	access  sync.RWMutex
+	mode    string
+	history *urltest.HistoryStorage
+}
+
+func newModeServer(ctx context.Context) *modeServer {
+	return &modeServer{
+		ctx:     ctx,
+		mode:    config.ModeRule,
+		history: urltest.NewHistoryStorage(),
+	}
+}
+
+func (s *modeServer) Name() string {
+	return "mode"
+}
+
+func (s *modeServer) Start(stage adapter.StartStage) error {
+	return nil
+}
+
+func (s *modeServer) Close() error {
+	return nil
+}
+
+func (s *modeServer) Mode() string {
+	s.access.RLock()
+	defer s.access.RUnlock()
+	return s.mode
+}
+
+func (s *modeServer) ModeList() []string {
+	return []string{config.ModeRule, config.ModeGlobal, config.ModeDirect}
+}
+
+// SetMode 切换路由模式并清空 DNS 缓存，未知模式忽略，与 Clash API 行为一致
+func (s *modeServer) SetMode(mode string) {
+	for _, known := range s.ModeList() {
+		if !strings.EqualFold(known, mode) {
+			continue
+		}
+		s.access.Lock()
+		changed := s.mode != known
+		s.mode = known
+		s.access.Unlock()
+		if router := service.FromContext[adapter.DNSRouter](s.ctx); changed && router != nil {
+			router.ClearCache()
+		}
+		return
+	}
+}
+
+func (s *modeServer) HistoryStorage() adapter.URLTestHistoryStorage {
+	return s.history
+}
+
+func (s *modeServer) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	return conn
+}
+
+func (s *modeServer) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) N.PacketConn {
+	return conn
+}
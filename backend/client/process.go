@@ -0,0 +1,72 @@
+package client
+
+import (
+	"github.com/danbai225/gpp/backend/config"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+)
+
+// processSplitRules 生成按进程分流的路由规则，当前系统没有可用条件时不分流
+func processSplitRules(split *config.ProcessSplit) []option.Rule {
+	if split == nil {
+		return nil
+	}
+	matchers := processMatchers(split)
+	if len(matchers) == 0 {
+		return nil
+	}
+	if split.Mode == config.ProcessSplitExclude {
+		return []option.Rule{processRule(matchers, false, "direct")}
+	}
+	return []option.Rule{
+		processRule(matchers, false, "proxy"),
+		processRule(matchers, true, "direct"),
+	}
+}
+
+// processMatchers 按进程名、路径、用户 ID 生成匹配条件，不支持的用户 ID 忽略
+func processMatchers(split *config.ProcessSplit) []option.RawDefaultRule {
+	var matchers []option.RawDefaultRule
+	if len(split.ProcessNames) > 0 {
+		matchers = append(matchers, option.RawDefaultRule{ProcessName: split.ProcessNames})
+	}
+	if len(split.ProcessPaths) > 0 {
+		matchers = append(matchers, option.RawDefaultRule{ProcessPath: split.ProcessPaths})
+	}
+	if uids := split.SupportedUIDs(); len(uids) > 0 {
+		matchers = append(matchers, option.RawDefaultRule{UserID: uids})
+	}
+	return matchers
+}
+
+// processRule 进程名、路径、用户 ID 之间为或关系，多于一类时使用逻辑规则
+func processRule(matchers []option.RawDefaultRule, invert bool, outbound string) option.Rule {
+	if len(matchers) == 1 {
+		matcher := matchers[0]
+		matcher.Invert = invert
+		return routeRule(matcher, outbound)
+	}
+	rules := make([]option.Rule, len(matchers))
+	for i, matcher := range matchers {
+		rules[i] = option.Rule{
+			Type:           C.RuleTypeDefault,
+			DefaultOptions: option.DefaultRule{RawDefaultRule: matcher},
+		}
+	}
+	return option.Rule{
+		Type: C.RuleTypeLogical,
+		LogicalOptions: option.LogicalRule{
+			RawLogicalRule: option.RawLogicalRule{
+				Mode:   C.LogicalTypeOr,
+				Rules:  rules,
+				Invert: invert,
+			},
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: outbound,
+				},
+			},
+		},
+	}
+}
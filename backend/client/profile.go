@@ -0,0 +1,72 @@
+package client
+
+import (
+	"github.com/danbai225/gpp/backend/config"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+// profileRules 将游戏配置转换为路由规则，域名与 IP、端口、进程分别成规则，任一命中即生效
+func profileRules(profiles []*config.GameProfile) []option.Rule {
+	var rules []option.Rule
+	for _, profile := range profiles {
+		var matchers []option.RawDefaultRule
+		if len(profile.Domains) > 0 || len(profile.CIDRs) > 0 {
+			matchers = append(matchers, option.RawDefaultRule{
+				DomainSuffix: profile.Domains,
+				IPCIDR:       profile.CIDRs,
+			})
+		}
+		if len(profile.ProcessNames) > 0 {
+			matchers = append(matchers, option.RawDefaultRule{
+				ProcessName: profile.ProcessNames,
+			})
+		}
+		if profile.QUIC != config.QUICAllow {
+			for _, matcher := range matchers {
+				matcher.Network = badoption.Listable[string]{"udp"}
+				matcher.Port = badoption.Listable[uint16]{443}
+				rules = append(rules, routeRule(matcher, "block"))
+			}
+		}
+		if len(profile.Ports) > 0 {
+			matchers = append(matchers, portMatcher(profile.Ports))
+		}
+		for _, matcher := range matchers {
+			rules = append(rules, routeRule(matcher, profile.Outbound))
+		}
+	}
+	return rules
+}
+
+func portMatcher(ports []string) option.RawDefaultRule {
+	var matcher option.RawDefaultRule
+	for _, port := range ports {
+		from, to, err := config.ParsePortRange(port)
+		if err != nil {
+			continue
+		}
+		if from == to {
+			matcher.Port = append(matcher.Port, from)
+		} else {
+			matcher.PortRange = append(matcher.PortRange, port)
+		}
+	}
+	return matcher
+}
+
+func routeRule(matcher option.RawDefaultRule, outbound string) option.Rule {
+	return option.Rule{
+		Type: "default",
+		DefaultOptions: option.DefaultRule{
+			RawDefaultRule: matcher,
+			RuleAction: option.RuleAction{
+				Action: C.RuleActionTypeRoute,
+				RouteOptions: option.RouteActionOptions{
+					Outbound: outbound,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danbai225/gpp/backend/data"
+)
+
+// rateInterval 速率采样间隔
+const rateInterval = time.Second
+
+// rateSampler 定时按出站采样速率，保留最近的历史和本次加速的峰值
+type rateSampler struct {
+	tracker *Tracker
+	access  sync.Mutex
+	samples []data.RateSample
+	next    int
+	last    map[string]data.OutboundTraffic
+	lastAt  time.Time
+	peak    map[string]*data.OutboundRate
+	close   chan struct{}
+	done    sync.WaitGroup
+}
+
+// newRateSampler 创建速率采样器，history 为保留的历史时长
+func newRateSampler(tracker *Tracker, history time.Duration) *rateSampler {
+	size := max(int(history/rateInterval), 1)
+	return &rateSampler{
+		tracker: tracker,
+		samples: make([]data.RateSample, 0, size),
+		last:    make(map[string]data.OutboundTraffic),
+		peak:    make(map[string]*data.OutboundRate),
+		close:   make(chan struct{}),
+	}
+}
+
+// Start 以当前流量为起点开始采样
+func (s *rateSampler) Start() {
+	s.sample(time.Now())
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(rateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.close:
+				return
+			case now := <-ticker.C:
+				s.sample(now)
+			}
+		}
+	}()
+}
+
+func (s *rateSampler) Close() {
+	close(s.close)
+	s.done.Wait()
+}
+
+// sample 根据与上次采样的流量差计算每秒字节数，首次调用只记录起点
+func (s *rateSampler) sample(now time.Time) {
+	traffic := s.tracker.Traffic()
+	s.access.Lock()
+	defer s.access.Unlock()
+	elapsed := now.Sub(s.lastAt).Seconds()
+	first := s.lastAt.IsZero()
+	s.lastAt = now
+	rates := make([]data.OutboundRate, 0, len(traffic))
+	for _, current := range traffic {
+		last := s.last[current.Outbound]
+		s.last[current.Outbound] = current
+		if first || elapsed <= 0 {
+			continue
+		}
+		rate := data.OutboundRate{
+			Outbound: current.Outbound,
+			Up:       uint64(float64(current.Up-last.Up) / elapsed),
+			Down:     uint64(float64(current.Down-last.Down) / elapsed),
+		}
+		rates = append(rates, rate)
+		peak, loaded := s.peak[rate.Outbound]
+		if !loaded {
+			peak = &data.OutboundRate{Outbound: rate.Outbound}
+			s.peak[rate.Outbound] = peak
+		}
+		peak.Up = max(peak.Up, rate.Up)
+		peak.Down = max(peak.Down, rate.Down)
+	}
+	if first || elapsed <= 0 {
+		return
+	}
+	sample := data.RateSample{Time: now, Rates: rates}
+	if len(s.samples) < cap(s.samples) {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+		s.next = (s.next + 1) % len(s.samples)
+	}
+}
+
+// Rates 返回当前速率、峰值和按时间排序的历史
+func (s *rateSampler) Rates() data.Rates {
+	s.access.Lock()
+	defer s.access.Unlock()
+	rates := data.Rates{
+		History: make([]data.RateSample, 0, len(s.samples)),
+	}
+	rates.History = append(rates.History, s.samples[s.next:]...)
+	rates.History = append(rates.History, s.samples[:s.next]...)
+	if len(rates.History) > 0 {
+		rates.Current = rates.History[len(rates.History)-1].Rates
+		for _, current := range rates.Current {
+			if peak, loaded := s.peak[current.Outbound]; loaded {
+				rates.Peak = append(rates.Peak, *peak)
+			}
+		}
+	}
+	return rates
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+	"github.com/miekg/dns"
+	"github.com/sagernet/sing-box/adapter"
+	C "github.com/sagernet/sing-box/constant"
+	dnsutil "github.com/sagernet/sing-box/dns"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	peerSubnetType   = "peer_subnet"
+	proxyDNSUpstream = "proxyDnsUpstream"
+	// egressURL 返回请求方 IP 的地址，响应中包含 ip=<地址> 行
+	egressURL = "https://www.cloudflare.com/cdn-cgi/trace"
+	// egressRetry 出口 IP 探测失败后的重试间隔
+	egressRetry = time.Minute
+	// subnetCheckInterval 检查当前节点的间隔，命中缓存的查询不经过本服务器，需要主动检查节点切换
+	subnetCheckInterval = time.Second
+)
+
+// PeerSubnetOptions 按当前节点附加 EDNS 客户端子网的 DNS 服务器参数
+type PeerSubnetOptions struct {
+	// Server 实际查询的 DNS 服务器
+	Server string `json:"server"`
+	// Group 节点所在的选择器
+	Group string `json:"group"`
+	// Subnets 节点 tag 到客户端子网，auto 为按出口 IP 推导
+	Subnets map[string]string `json:"subnets"`
+}
+
+func registerPeerSubnet(registry *dnsutil.TransportRegistry) {
+	dnsutil.RegisterTransport[PeerSubnetOptions](registry, peerSubnetType, newPeerSubnet)
+}
+
+// peerSubnetServer 生成包装代理 DNS 的服务器，未配置客户端子网的节点不附加
+func peerSubnetServer(peers []*config.Peer) (option.DNSServerOptions, bool) {
+	subnets := make(map[string]string)
+	for _, peer := range peers {
+		if peer.ClientSubnet != "" {
+			subnets[peerTag(peer.Name)] = peer.ClientSubnet
+		}
+	}
+	if len(subnets) == 0 {
+		return option.DNSServerOptions{}, false
+	}
+	return option.DNSServerOptions{
+		Type: peerSubnetType,
+		Tag:  "proxyDns",
+		Options: &PeerSubnetOptions{
+			Server:  proxyDNSUpstream,
+			Group:   "proxy",
+			Subnets: subnets,
+		},
+	}, true
+}
+
+// peerSubnet 查询经过当前节点时附加该节点的客户端子网，子网变化时清空 DNS 缓存
+type peerSubnet struct {
+	dnsutil.TransportAdapter
+	ctx       context.Context
+	logger    log.ContextLogger
+	server    string
+	group     string
+	subnets   map[string]netip.Prefix
+	auto      map[string]bool
+	outbound  adapter.OutboundManager
+	transport adapter.DNSTransportManager
+	upstream  adapter.DNSTransport
+	access    sync.Mutex
+	egress    map[string]netip.Prefix
+	retryAt   map[string]time.Time
+	last      netip.Prefix
+	cancel    context.CancelFunc
+	done      sync.WaitGroup
+}
+
+func newPeerSubnet(ctx context.Context, logger log.ContextLogger, tag string, options PeerSubnetOptions) (adapter.DNSTransport, error) {
+	// 关闭时取消定时检查和出口 IP 探测
+	ctx, cancel := context.WithCancel(ctx)
+	t := &peerSubnet{
+		TransportAdapter: dnsutil.NewTransportAdapter(peerSubnetType, tag, []string{options.Server}),
+		ctx:              ctx,
+		cancel:           cancel,
+		logger:           logger,
+		server:           options.Server,
+		group:            options.Group,
+		subnets:          make(map[string]netip.Prefix),
+		auto:             make(map[string]bool),
+		outbound:         service.FromContext[adapter.OutboundManager](ctx),
+		transport:        service.FromContext[adapter.DNSTransportManager](ctx),
+		egress:           make(map[string]netip.Prefix),
+		retryAt:          make(map[string]time.Time),
+	}
+	for peer, subnet := range options.Subnets {
+		if subnet == config.ClientSubnetAuto {
+			t.auto[peer] = true
+			continue
+		}
+		prefix, err := config.ParseClientSubnet(subnet)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		t.subnets[peer] = prefix
+	}
+	return t, nil
+}
+
+func (t *peerSubnet) Start(stage adapter.StartStage) error {
+	switch stage {
+	case adapter.StartStateStart:
+		upstream, loaded := t.transport.Transport(t.server)
+		if !loaded {
+			return E.New("dns server not found: ", t.server)
+		}
+		t.upstream = upstream
+	case adapter.StartStatePostStart:
+		t.done.Add(1)
+		go func() {
+			defer t.done.Done()
+			ticker := time.NewTicker(subnetCheckInterval)
+			defer ticker.Stop()
+			for {
+				t.current()
+				select {
+				case <-t.ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Close 取消并等待定时检查和进行中的出口 IP 探测
+func (t *peerSubnet) Close() error {
+	t.access.Lock()
+	t.cancel()
+	t.access.Unlock()
+	t.done.Wait()
+	return nil
+}
+
+func (t *peerSubnet) Exchange(ctx context.Context, message *dns.Msg) (*dns.Msg, error) {
+	// 规则已指定客户端子网时不覆盖
+	if prefix := t.current(); prefix.IsValid() && !hasClientSubnet(message) {
+		message = dnsutil.SetClientSubnet(message, prefix)
+	}
+	return t.upstream.Exchange(ctx, message)
+}
+
+// current 返回当前节点的客户端子网
+func (t *peerSubnet) current() netip.Prefix {
+	tag := t.activePeer()
+	t.access.Lock()
+	prefix, loaded := t.subnets[tag]
+	if !loaded && t.auto[tag] {
+		prefix, loaded = t.egress[tag]
+		if !loaded && t.ctx.Err() == nil && time.Now().After(t.retryAt[tag]) {
+			// 探测期间不附加子网，避免阻塞查询
+			t.retryAt[tag] = time.Now().Add(egressRetry)
+			t.done.Add(1)
+			go func() {
+				defer t.done.Done()
+				t.detect(t.ctx, tag)
+			}()
+		}
+	}
+	changed := prefix != t.last
+	t.last = prefix
+	t.access.Unlock()
+	if changed {
+		service.FromContext[adapter.DNSRouter](t.ctx).ClearCache()
+	}
+	return prefix
+}
+
+// activePeer 返回当前实际使用的节点
+func (t *peerSubnet) activePeer() string {
+	return activeOutbound(t.outbound, t.group)
+}
+
+// detect 通过节点请求出口 IP，IPv4 取 /24，IPv6 取 /56
+func (t *peerSubnet) detect(ctx context.Context, tag string) {
+	addr, err := t.egressIP(ctx, tag)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		t.logger.Warn("detect egress ip of ", tag, ": ", err)
+		return
+	}
+	bits := 24
+	if addr.Is6() {
+		bits = 56
+	}
+	prefix, _ := addr.Prefix(bits)
+	t.access.Lock()
+	t.egress[tag] = prefix
+	t.access.Unlock()
+	t.logger.Info("client subnet of ", tag, ": ", prefix)
+}
+
+func (t *peerSubnet) egressIP(ctx context.Context, tag string) (netip.Addr, error) {
+	out, loaded := t.outbound.Outbound(tag)
+	if !loaded {
+		return netip.Addr{}, E.New("outbound not found: ", tag)
+	}
+	client := &http.Client{
+		Timeout: C.TCPTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return out.DialContext(ctx, network, M.ParseSocksaddr(addr))
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, egressURL, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "ip="); found {
+			return netip.ParseAddr(value)
+		}
+	}
+	return netip.Addr{}, E.New("ip not found in response")
+}
+
+func hasClientSubnet(message *dns.Msg) bool {
+	if opt := message.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if _, isSubnet := option.(*dns.EDNS0_SUBNET); isSubnet {
+				return true
+			}
+		}
+	}
+	return false
+}
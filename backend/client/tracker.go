@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danbai225/gpp/backend/data"
+	"github.com/google/uuid"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common/bufio"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// Tracker 记录经过路由的活动连接、命中的规则和流量，并按出站累计本次加速的流量
+type Tracker struct {
+	access  sync.Mutex
+	conns   map[string]*trackedEntry
+	traffic map[string]*trafficCounter
+}
+
+type trafficCounter struct {
+	up   atomic.Int64
+	down atomic.Int64
+}
+
+// trafficOrder 固定显示的出站，未产生流量时也返回
+var trafficOrder = []string{"game", "http", "direct", "block"}
+
+type trackedEntry struct {
+	info   data.Connection
+	up     atomic.Int64
+	down   atomic.Int64
+	closer io.Closer
+}
+
+// NewTracker 创建连接追踪器
+func NewTracker() *Tracker {
+	traffic := make(map[string]*trafficCounter, len(trafficOrder))
+	for _, kind := range trafficOrder {
+		traffic[kind] = new(trafficCounter)
+	}
+	return &Tracker{
+		conns:   make(map[string]*trackedEntry),
+		traffic: traffic,
+	}
+}
+
+// RoutedConnection 从入站连接读取的为上传，写入的为下载
+func (t *Tracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) net.Conn {
+	entry := t.newEntry(metadata, matchedRule, matchOutbound)
+	up, down := t.counters(entry)
+	counter := bufio.NewInt64CounterConn(conn, up, down)
+	tracked := &trackedConn{Conn: counter, onClose: func() { t.remove(entry.info.ID) }}
+	t.add(entry, tracked)
+	return tracked
+}
+
+func (t *Tracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) N.PacketConn {
+	entry := t.newEntry(metadata, matchedRule, matchOutbound)
+	up, down := t.counters(entry)
+	counter := bufio.NewInt64CounterPacketConn(conn, up, nil, down, nil)
+	tracked := &trackedPacketConn{PacketConn: counter, onClose: func() { t.remove(entry.info.ID) }}
+	t.add(entry, tracked)
+	return tracked
+}
+
+// Connections 返回按建立时间排序的活动连接
+func (t *Tracker) Connections() []data.Connection {
+	t.access.Lock()
+	defer t.access.Unlock()
+	list := make([]data.Connection, 0, len(t.conns))
+	for _, entry := range t.conns {
+		conn := entry.info
+		conn.Up = uint64(entry.up.Load())
+		conn.Down = uint64(entry.down.Load())
+		list = append(list, conn)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// Traffic 返回按出站累计的流量，包含已关闭的连接
+func (t *Tracker) Traffic() []data.OutboundTraffic {
+	t.access.Lock()
+	defer t.access.Unlock()
+	list := make([]data.OutboundTraffic, 0, len(t.traffic))
+	for kind, counter := range t.traffic {
+		list = append(list, data.OutboundTraffic{
+			Outbound: kind,
+			Up:       uint64(counter.up.Load()),
+			Down:     uint64(counter.down.Load()),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return trafficRank(list[i].Outbound) < trafficRank(list[j].Outbound) ||
+			trafficRank(list[i].Outbound) == trafficRank(list[j].Outbound) && list[i].Outbound < list[j].Outbound
+	})
+	return list
+}
+
+// trafficRank 固定显示的出站在前，其余按名称排序
+func trafficRank(kind string) int {
+	for i, k := range trafficOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(trafficOrder)
+}
+
+// counters 返回连接和所属出站的计数器，未匹配出站的连接只计入连接本身
+func (t *Tracker) counters(entry *trackedEntry) (up, down []*atomic.Int64) {
+	up = []*atomic.Int64{&entry.up}
+	down = []*atomic.Int64{&entry.down}
+	kind := entry.info.Outbound
+	if kind == "" {
+		return
+	}
+	t.access.Lock()
+	counter, loaded := t.traffic[kind]
+	if !loaded {
+		counter = new(trafficCounter)
+		t.traffic[kind] = counter
+	}
+	t.access.Unlock()
+	return append(up, &counter.up), append(down, &counter.down)
+}
+
+// Close 关闭指定连接
+func (t *Tracker) Close(id string) error {
+	t.access.Lock()
+	entry, loaded := t.conns[id]
+	t.access.Unlock()
+	if !loaded {
+		return fmt.Errorf("connection not found: %s", id)
+	}
+	return entry.closer.Close()
+}
+
+func (t *Tracker) newEntry(metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) *trackedEntry {
+	conn := data.Connection{
+		ID:          uuid.New().String(),
+		Network:     metadata.Network,
+		Inbound:     metadata.Inbound,
+		Source:      metadata.Source.String(),
+		Destination: metadata.Destination.String(),
+		Domain:      metadata.Domain,
+		Rule:        "final",
+		Start:       time.Now(),
+	}
+	if metadata.Destination.IsIP() {
+		conn.IP = metadata.Destination.Addr.String()
+	} else if len(metadata.DestinationAddresses) > 0 {
+		conn.IP = metadata.DestinationAddresses[0].String()
+	}
+	if metadata.ProcessInfo != nil {
+		conn.Process = metadata.ProcessInfo.ProcessPath
+		if conn.Process == "" && metadata.ProcessInfo.UserId != -1 {
+			conn.Process = fmt.Sprintf("uid %d", metadata.ProcessInfo.UserId)
+		}
+	}
+	if matchedRule != nil {
+		conn.Rule = fmt.Sprintf("%s => %s", matchedRule, matchedRule.Action())
+	}
+	if matchOutbound != nil {
+		conn.Outbound = outboundKind(matchOutbound.Tag())
+	}
+	return &trackedEntry{info: conn}
+}
+
+func (t *Tracker) add(entry *trackedEntry, closer io.Closer) {
+	entry.closer = closer
+	t.access.Lock()
+	t.conns[entry.info.ID] = entry
+	t.access.Unlock()
+}
+
+// outboundKind 将出站 tag 归类为 game、http、direct、block、dns，其余保持原样
+func outboundKind(tag string) string {
+	switch tag {
+	case "proxy", killSwitchTag:
+		return "game"
+	case loadBalanceTag:
+		return "http"
+	case "dns_out":
+		return "dns"
+	default:
+		return tag
+	}
+}
+
+func (t *Tracker) remove(id string) {
+	t.access.Lock()
+	delete(t.conns, id)
+	t.access.Unlock()
+}
+
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) Upstream() any {
+	return c.Conn
+}
+
+type trackedPacketConn struct {
+	N.PacketConn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedPacketConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.PacketConn.Close()
+}
+
+func (c *trackedPacketConn) Upstream() any {
+	return c.PacketConn
+}
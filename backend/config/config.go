@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -22,8 +23,15 @@ type Peer struct {
 	Addr     string `json:"addr"`
 	UUID     string `json:"uuid"`
 	Ping     uint   `json:"ping"`
+	// Detour 前置节点名称，通过该节点连接本节点
+	Detour string `json:"detour,omitempty"`
+	// ClientSubnet 经该节点的代理 DNS 查询附加的 EDNS 客户端子网，auto 为按节点出口 IP 推导
+	ClientSubnet string `json:"client_subnet,omitempty"`
 }
 
+// ClientSubnetAuto 按节点出口 IP 推导客户端子网
+const ClientSubnetAuto = "auto"
+
 func (p *Peer) Domain() string {
 	host := strings.Split(p.Addr, ":")[0]
 	_, err := netip.ParseAddr(host)
@@ -33,6 +41,34 @@ func (p *Peer) Domain() string {
 	return "placeholder.com"
 }
 
+// DetourChain 返回从节点到最终前置节点的代理链，前置节点不存在或成环时返回错误
+func DetourChain(peer *Peer, list []*Peer) ([]*Peer, error) {
+	chain := []*Peer{peer}
+	visited := map[string]bool{peer.Name: true}
+	for peer.Detour != "" {
+		var detour *Peer
+		for _, p := range list {
+			if p.Name == peer.Detour {
+				detour = p
+				break
+			}
+		}
+		if detour == nil {
+			return nil, fmt.Errorf("detour peer not found: %s", peer.Detour)
+		}
+		if detour.Protocol == "direct" {
+			return nil, fmt.Errorf("detour peer cannot be direct: %s", detour.Name)
+		}
+		if visited[detour.Name] {
+			return nil, fmt.Errorf("detour cycle: %s -> %s", peer.Name, detour.Name)
+		}
+		visited[detour.Name] = true
+		chain = append(chain, detour)
+		peer = detour
+	}
+	return chain, nil
+}
+
 type Config struct {
 	PeerList []*Peer       `json:"peer_list"`
 	SubAddr  string        `json:"sub_addr"`
@@ -41,7 +77,232 @@ type Config struct {
 	HTTPPeer string        `json:"http_peer"`
 	ProxyDNS string        `json:"proxy_dns"`
 	LocalDNS string        `json:"local_dns"`
-	Debug    bool          `json:"debug"`
+	// RuleSets 规则集，规则中通过 rule_set 引用其 tag
+	RuleSets []option.RuleSet `json:"rule_sets,omitempty"`
+	// Profiles 启用的游戏配置名称，未配置时启用内置的 steam
+	Profiles []string `json:"profiles"`
+	// ProcessSplit 按进程分流
+	ProcessSplit *ProcessSplit `json:"process_split,omitempty"`
+	// Failover 游戏流量故障转移，启用后游戏流量走节点组而不是单个游戏节点
+	Failover *Failover `json:"failover,omitempty"`
+	// LoadBalance HTTP 流量负载均衡，启用后 HTTP 流量分摊到多个节点
+	LoadBalance *LoadBalance `json:"load_balance,omitempty"`
+	// KillSwitch 断网保护，游戏节点不可用时拦截游戏流量，不从本机直接发出
+	KillSwitch *KillSwitch `json:"kill_switch,omitempty"`
+	// DNS 自定义 DNS 服务器、规则和静态解析，规则优先于内置规则
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// FakeIP 启用后 DNS 返回虚假地址，连接时按域名路由，映射保存在缓存文件中
+	FakeIP *FakeIPConfig `json:"fakeip,omitempty"`
+	// Mode 路由模式 rule、global、direct，运行中可切换
+	Mode string `json:"mode"`
+	// InboundMode 入站模式 tun、proxy，proxy 模式不创建 TUN，无需管理员权限
+	InboundMode string     `json:"inbound_mode"`
+	Tun         *TunConfig `json:"tun,omitempty"`
+	// LocalProxy 本地代理入站，两种入站模式下均生效
+	LocalProxy *LocalProxyConfig `json:"local_proxy,omitempty"`
+	// RateHistory 保留的速率历史分钟数，默认 5
+	RateHistory uint32 `json:"rate_history"`
+	// ClashAPI 兼容 Clash 的控制接口，配置后监听，供外部面板和脚本使用
+	ClashAPI *ClashAPIConfig `json:"clash_api,omitempty"`
+	// DNSStrategy 解析策略 prefer_ipv4、prefer_ipv6、ipv4_only
+	DNSStrategy string `json:"dns_strategy"`
+	// BlockIPv6 拦截全部 IPv6 流量
+	BlockIPv6 bool `json:"block_ipv6"`
+	Debug     bool `json:"debug"`
+}
+
+const (
+	// ModeRule 按规则分流
+	ModeRule = "rule"
+	// ModeGlobal 除局域网外全部走游戏节点
+	ModeGlobal = "global"
+	// ModeDirect 全部直连，仍保留流量统计
+	ModeDirect = "direct"
+)
+
+const (
+	// InboundModeTun 通过 TUN 接管全部流量
+	InboundModeTun = "tun"
+	// InboundModeProxy 仅提供本地 HTTP+SOCKS5 代理
+	InboundModeProxy = "proxy"
+)
+
+// TunConfig TUN 网卡参数
+type TunConfig struct {
+	InterfaceName string `json:"interface_name"`
+	MTU           uint32 `json:"mtu"`
+	Address       string `json:"address"`
+	Inet6Address  string `json:"inet6_address"`
+	// Stack 网络栈 system、gvisor、mixed
+	Stack       string `json:"stack"`
+	StrictRoute *bool  `json:"strict_route,omitempty"`
+	// UDPTimeout UDP 会话超时，单位秒
+	UDPTimeout uint32 `json:"udp_timeout"`
+}
+
+// DNSConfig 自定义 DNS 参数
+type DNSConfig struct {
+	Servers []*DNSServer `json:"servers,omitempty"`
+	Rules   []*DNSRule   `json:"rules,omitempty"`
+	// Hosts 静态解析，域名到地址列表
+	Hosts map[string][]string `json:"hosts,omitempty"`
+}
+
+// DNSServer 自定义 DNS 服务器
+type DNSServer struct {
+	Tag     string `json:"tag"`
+	Address string `json:"address"`
+	// Detour 查询经过的出站 proxy、direct，默认 proxy
+	Detour string `json:"detour"`
+}
+
+// DNSRule 自定义 DNS 规则，域名后缀或规则集任一命中即使用指定服务器
+type DNSRule struct {
+	Domains  []string `json:"domains,omitempty"`
+	RuleSets []string `json:"rule_sets,omitempty"`
+	// Server 服务器 tag，可使用自定义服务器或内置的 proxyDns、localDns、block
+	Server string `json:"server"`
+	// ClientSubnet 查询附加的 EDNS 客户端子网，如 203.0.113.0/24
+	ClientSubnet string `json:"client_subnet,omitempty"`
+}
+
+// FakeIPConfig FakeIP 参数
+type FakeIPConfig struct {
+	// Inet4Range IPv4 虚假地址段，默认 198.18.0.0/15
+	Inet4Range string `json:"inet4_range"`
+	// Inet6Range IPv6 虚假地址段，默认 fc00::/18
+	Inet6Range string `json:"inet6_range"`
+	// ExcludeDomains 返回真实地址的域名后缀
+	ExcludeDomains []string `json:"exclude_domains,omitempty"`
+	// ExcludeRuleSets 返回真实地址的规则集 tag
+	ExcludeRuleSets []string `json:"exclude_rule_sets,omitempty"`
+}
+
+const (
+	// ProcessSplitInclude 仅列出的进程走游戏节点，其余直连
+	ProcessSplitInclude = "include"
+	// ProcessSplitExclude 列出的进程直连，其余按规则分流
+	ProcessSplitExclude = "exclude"
+)
+
+// ProcessSplit 按进程分流参数，进程名、路径、用户 ID 任一命中即视为匹配
+type ProcessSplit struct {
+	// Mode 分流模式 include、exclude，默认 include
+	Mode         string   `json:"mode"`
+	ProcessNames []string `json:"process_names,omitempty"`
+	ProcessPaths []string `json:"process_paths,omitempty"`
+	// UIDs 用户 ID，仅 Linux 支持，其他系统忽略
+	UIDs []int32 `json:"uids,omitempty"`
+}
+
+// processUIDSupported 当前系统是否支持按用户 ID 分流
+var processUIDSupported = runtime.GOOS == "linux"
+
+// SupportedUIDs 返回当前系统可用的用户 ID，不支持时返回 nil，配置中仍保留
+func (s *ProcessSplit) SupportedUIDs() []int32 {
+	if !processUIDSupported {
+		return nil
+	}
+	return s.UIDs
+}
+
+// DefaultProbeURL 默认的延迟和健康检查地址
+const DefaultProbeURL = "https://www.gstatic.com/generate_204"
+
+const (
+	// DefaultProxyDNS 默认代理 DNS
+	DefaultProxyDNS = "https://1.1.1.1/dns-query"
+	// DefaultLocalDNS 默认直连 DNS
+	DefaultLocalDNS = "https://223.5.5.5/dns-query"
+)
+
+const (
+	// FailoverURLTest 选择延迟最低的节点
+	FailoverURLTest = "urltest"
+	// FailoverFallback 按顺序选择第一个可用节点
+	FailoverFallback = "fallback"
+)
+
+// Failover 故障转移节点组参数
+type Failover struct {
+	// Type 节点组类型 urltest、fallback，默认 urltest
+	Type  string   `json:"type"`
+	Peers []string `json:"peers"`
+	// URL 探测地址
+	URL string `json:"url"`
+	// Interval 探测间隔，单位秒
+	Interval uint32 `json:"interval"`
+	// Tolerance 延迟容差，单位毫秒，仅 urltest 使用
+	Tolerance uint16 `json:"tolerance"`
+}
+
+const (
+	// BalanceRoundRobin 轮询
+	BalanceRoundRobin = "round_robin"
+	// BalanceConsistentHash 按目标一致性哈希，同一目标固定走同一节点
+	BalanceConsistentHash = "consistent_hash"
+)
+
+// KillSwitch 断网保护参数
+type KillSwitch struct {
+	// URL 检查游戏节点的探测地址
+	URL string `json:"url"`
+	// Interval 探测间隔，单位秒，默认 10
+	Interval uint32 `json:"interval"`
+}
+
+// LoadBalance 负载均衡参数
+type LoadBalance struct {
+	// Strategy 均衡策略 round_robin、consistent_hash，默认 round_robin
+	Strategy string   `json:"strategy"`
+	Peers    []string `json:"peers"`
+	// URL 健康检查地址
+	URL string `json:"url"`
+	// Interval 健康检查间隔，单位秒
+	Interval uint32 `json:"interval"`
+}
+
+// LocalProxyConfig 本地代理入站参数
+type LocalProxyConfig struct {
+	// Type 代理类型 mixed、http、socks
+	Type     string `json:"type"`
+	Listen   string `json:"listen"`
+	Port     uint16 `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// AllowLAN 允许监听非回环地址，供局域网设备使用
+	AllowLAN bool `json:"allow_lan"`
+}
+
+// ClashAPIConfig Clash 控制接口参数
+type ClashAPIConfig struct {
+	// Listen 监听地址，默认 127.0.0.1:9090
+	Listen string `json:"listen"`
+	// Secret 访问密钥，监听非回环地址时必填
+	Secret string `json:"secret,omitempty"`
+}
+
+// DefaultLocalProxyConfig 默认仅监听本机的 mixed 代理
+func DefaultLocalProxyConfig() *LocalProxyConfig {
+	return &LocalProxyConfig{
+		Type:   "mixed",
+		Listen: "127.0.0.1",
+		Port:   5123,
+	}
+}
+
+// DefaultTunConfig 默认 TUN 参数
+func DefaultTunConfig() *TunConfig {
+	strictRoute := true
+	return &TunConfig{
+		InterfaceName: "utun225",
+		MTU:           1420,
+		Address:       "172.25.0.1/30",
+		Inet6Address:  "fdfe:dcba:9876::1/126",
+		Stack:         "system",
+		StrictRoute:   &strictRoute,
+		UDPTimeout:    300,
+	}
 }
 
 // InitConfig 初始化配置文件（使用新的配置加载器）
@@ -11,3 +11,62 @@ func TestParsePeer(t *testing.T) {
 		t.Error("peer is nil")
 	}
 }
+
+func TestDetourChain(t *testing.T) {
+	a := &Peer{Name: "a", Protocol: "vless", Detour: "b"}
+	b := &Peer{Name: "b", Protocol: "vless", Detour: "c"}
+	c := &Peer{Name: "c", Protocol: "vless"}
+	chain, err := DetourChain(a, []*Peer{a, b, c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0] != a || chain[2] != c {
+		t.Errorf("unexpected chain: %v", chain)
+	}
+
+	c.Detour = "a"
+	if _, err = DetourChain(a, []*Peer{a, b, c}); err == nil {
+		t.Error("expected cycle error")
+	}
+	c.Detour = "d"
+	if _, err = DetourChain(a, []*Peer{a, b, c}); err == nil {
+		t.Error("expected not found error")
+	}
+	c.Detour = "直连"
+	if _, err = DetourChain(a, []*Peer{a, b, c, {Name: "直连", Protocol: "direct"}}); err == nil {
+		t.Error("expected direct detour error")
+	}
+}
+
+func TestParseDNSAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    DNSAddress
+	}{
+		{"8.8.8.8", DNSAddress{Type: "udp", Host: "8.8.8.8"}},
+		{"1.1.1.1:5353", DNSAddress{Type: "udp", Host: "1.1.1.1", Port: 5353}},
+		{"tcp://[2001:4860:4860::8888]:53", DNSAddress{Type: "tcp", Host: "2001:4860:4860::8888", Port: 53}},
+		{"tls://dns.google", DNSAddress{Type: "tls", Host: "dns.google"}},
+		{"quic://dns.adguard-dns.com", DNSAddress{Type: "quic", Host: "dns.adguard-dns.com"}},
+		{"https://223.5.5.5", DNSAddress{Type: "https", Host: "223.5.5.5", Path: "/dns-query"}},
+		{"h3://1.1.1.1/dns", DNSAddress{Type: "h3", Host: "1.1.1.1", Path: "/dns"}},
+		{"dhcp://auto", DNSAddress{Type: "dhcp"}},
+		{"dhcp://en0", DNSAddress{Type: "dhcp", Interface: "en0"}},
+		{"local", DNSAddress{Type: "local"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseDNSAddress(tt.address)
+		if err != nil {
+			t.Errorf("ParseDNSAddress(%s) failed: %v", tt.address, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseDNSAddress(%s) = %+v, want %+v", tt.address, *got, tt.want)
+		}
+	}
+	for _, address := range []string{"", "rcode://success", "tls://dns.google/path", "udp://8.8.8.8:0", "bad host"} {
+		if _, err := ParseDNSAddress(address); err == nil {
+			t.Errorf("ParseDNSAddress(%s): expected error", address)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	C "github.com/sagernet/sing-box/constant"
+)
+
+// DNSAddress 解析后的 DNS 服务器地址
+type DNSAddress struct {
+	// Type 服务器类型 udp、tcp、tls、quic、https、h3、dhcp、local
+	Type string
+	Host string
+	// Port 为 0 时使用协议默认端口
+	Port uint16
+	// Path DoH、DoH3 的请求路径
+	Path string
+	// Interface DHCP 使用的网卡，为空时自动选择
+	Interface string
+}
+
+// IsRemote 是否需要连接远程服务器
+func (a *DNSAddress) IsRemote() bool {
+	return a.Type != C.DNSTypeLocal && a.Type != C.DNSTypeDHCP
+}
+
+// ParseDNSAddress 解析 DNS 服务器地址
+// 支持 8.8.8.8、udp://、tcp://、tls://、quic://、https://、h3://、dhcp://auto、dhcp://<网卡>、local
+func ParseDNSAddress(address string) (*DNSAddress, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, fmt.Errorf("empty dns address")
+	}
+	if address == C.DNSTypeLocal {
+		return &DNSAddress{Type: C.DNSTypeLocal}, nil
+	}
+	if !strings.Contains(address, "://") {
+		return parseDNSHost(C.DNSTypeUDP, address)
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns address %s: %w", address, err)
+	}
+	switch u.Scheme {
+	case C.DNSTypeDHCP:
+		dhcp := &DNSAddress{Type: C.DNSTypeDHCP}
+		if u.Host != "auto" {
+			dhcp.Interface = u.Host
+		}
+		return dhcp, nil
+	case C.DNSTypeUDP, C.DNSTypeTCP, C.DNSTypeTLS, C.DNSTypeQUIC:
+		if u.Path != "" && u.Path != "/" {
+			return nil, fmt.Errorf("invalid dns address %s: unexpected path", address)
+		}
+		return parseDNSHost(u.Scheme, u.Host)
+	case C.DNSTypeHTTPS, C.DNSTypeHTTP3:
+		parsed, err := parseDNSHost(u.Scheme, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		parsed.Path = u.Path
+		if parsed.Path == "" || parsed.Path == "/" {
+			parsed.Path = "/dns-query"
+		}
+		return parsed, nil
+	default:
+		return nil, fmt.Errorf("unsupported dns scheme: %s", u.Scheme)
+	}
+}
+
+// parseDNSHost 解析 host[:port]，host 必须为 IP 或域名
+func parseDNSHost(dnsType, hostPort string) (*DNSAddress, error) {
+	parsed := &DNSAddress{Type: dnsType, Host: hostPort}
+	if addr, err := netip.ParseAddr(strings.Trim(hostPort, "[]")); err == nil {
+		parsed.Host = addr.String()
+		return parsed, nil
+	}
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		portNum, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || portNum == 0 {
+			return nil, fmt.Errorf("invalid dns server port: %s", hostPort)
+		}
+		parsed.Host = host
+		parsed.Port = uint16(portNum)
+	}
+	if _, err := netip.ParseAddr(parsed.Host); err != nil && !isDomain(parsed.Host) {
+		return nil, fmt.Errorf("invalid dns server host: %s", hostPort)
+	}
+	return parsed, nil
+}
+
+func isDomain(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -2,12 +2,21 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
 )
 
 // PathManager 管理配置文件路径
@@ -137,12 +146,107 @@ func (cv *ConfigValidator) Validate(conf *Config) error {
 		})
 	}
 	
-	// 设置默认 DNS
+	// 校验前置节点
+	if err := cv.validateDetours(conf); err != nil {
+		return err
+	}
+	
+	// 校验节点客户端子网
+	if err := cv.validateClientSubnets(conf); err != nil {
+		return err
+	}
+	
+	// 设置默认 DNS，已保存的地址保留原值，在设置 DNS 和启动加速时检查，避免旧配置无法加载
 	if conf.ProxyDNS == "" {
-		conf.ProxyDNS = "https://1.1.1.1/dns-query"
+		conf.ProxyDNS = DefaultProxyDNS
 	}
 	if conf.LocalDNS == "" {
-		conf.LocalDNS = "https://223.5.5.5/dns-query"
+		conf.LocalDNS = DefaultLocalDNS
+	}
+	
+	// 设置默认路由模式
+	switch conf.Mode {
+	case "":
+		conf.Mode = ModeRule
+	case ModeRule, ModeGlobal, ModeDirect:
+	default:
+		return fmt.Errorf("invalid mode: %s", conf.Mode)
+	}
+	
+	// 设置默认入站模式
+	switch conf.InboundMode {
+	case "":
+		conf.InboundMode = InboundModeTun
+	case InboundModeTun, InboundModeProxy:
+	default:
+		return fmt.Errorf("invalid inbound mode: %s", conf.InboundMode)
+	}
+	
+	// 设置默认解析策略
+	switch conf.DNSStrategy {
+	case "":
+		conf.DNSStrategy = "ipv4_only"
+	case "prefer_ipv4", "prefer_ipv6", "ipv4_only":
+	default:
+		return fmt.Errorf("invalid dns strategy: %s", conf.DNSStrategy)
+	}
+	
+	// 校验 TUN 参数
+	if err := cv.validateTun(conf); err != nil {
+		return err
+	}
+	
+	// 校验本地代理参数
+	if err := cv.validateLocalProxy(conf); err != nil {
+		return err
+	}
+	
+	// 校验 Clash 控制接口参数
+	if err := cv.validateClashAPI(conf); err != nil {
+		return err
+	}
+	
+	// 设置默认速率历史时长
+	if conf.RateHistory == 0 {
+		conf.RateHistory = 5
+	}
+	if conf.RateHistory > 60 {
+		return fmt.Errorf("invalid rate history: %d", conf.RateHistory)
+	}
+	
+	// 校验进程分流参数
+	if err := cv.validateProcessSplit(conf); err != nil {
+		return err
+	}
+	
+	// 校验故障转移参数
+	if err := cv.validateFailover(conf); err != nil {
+		return err
+	}
+	
+	// 校验负载均衡参数
+	if err := cv.validateLoadBalance(conf); err != nil {
+		return err
+	}
+	
+	// 校验断网保护参数
+	if err := cv.validateKillSwitch(conf); err != nil {
+		return err
+	}
+	
+	// 校验自定义 DNS
+	if err := cv.validateDNS(conf); err != nil {
+		return err
+	}
+	
+	// 校验 FakeIP 参数
+	if err := cv.validateFakeIP(conf); err != nil {
+		return err
+	}
+	
+	// 校验规则集引用
+	if err := cv.validateRuleSets(conf); err != nil {
+		return err
 	}
 	
 	// 激活调试模式
@@ -153,6 +257,471 @@ func (cv *ConfigValidator) Validate(conf *Config) error {
 	return nil
 }
 
+// validateTun 补全 TUN 默认值并校验
+func (cv *ConfigValidator) validateTun(conf *Config) error {
+	def := DefaultTunConfig()
+	if conf.Tun == nil {
+		conf.Tun = def
+		return nil
+	}
+	tun := conf.Tun
+	if tun.InterfaceName == "" {
+		tun.InterfaceName = def.InterfaceName
+	}
+	if tun.MTU == 0 {
+		tun.MTU = def.MTU
+	}
+	if tun.Address == "" {
+		tun.Address = def.Address
+	}
+	if tun.Stack == "" {
+		tun.Stack = def.Stack
+	}
+	if tun.StrictRoute == nil {
+		tun.StrictRoute = def.StrictRoute
+	}
+	if tun.UDPTimeout == 0 {
+		tun.UDPTimeout = def.UDPTimeout
+	}
+	if tun.MTU < 576 || tun.MTU > 65535 {
+		return fmt.Errorf("invalid tun mtu: %d", tun.MTU)
+	}
+	prefix, err := netip.ParsePrefix(tun.Address)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("invalid tun address: %s", tun.Address)
+	}
+	// IPv6 地址为空表示不分配 IPv6，默认值只在首次创建 TUN 配置时使用
+	if tun.Inet6Address != "" {
+		prefix, err = netip.ParsePrefix(tun.Inet6Address)
+		if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+			return fmt.Errorf("invalid tun inet6 address: %s", tun.Inet6Address)
+		}
+	}
+	switch tun.Stack {
+	case "system", "gvisor", "mixed":
+	default:
+		return fmt.Errorf("invalid tun stack: %s", tun.Stack)
+	}
+	return nil
+}
+
+// validateClashAPI 补全控制接口默认地址，监听非回环地址时必须设置密钥
+func (cv *ConfigValidator) validateClashAPI(conf *Config) error {
+	api := conf.ClashAPI
+	if api == nil {
+		return nil
+	}
+	if api.Listen == "" {
+		api.Listen = "127.0.0.1:9090"
+	}
+	host, port, err := net.SplitHostPort(api.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid clash api listen: %s", api.Listen)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid clash api listen port: %s", api.Listen)
+	}
+	// 主机名只有 localhost 视为回环地址
+	loopback := strings.EqualFold(host, "localhost")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		loopback = addr.IsLoopback()
+	}
+	if !loopback && api.Secret == "" {
+		return fmt.Errorf("clash api listen %s requires secret", api.Listen)
+	}
+	return nil
+}
+
+// validateLocalProxy 补全本地代理默认值，未开启局域网共享时只允许监听回环地址
+func (cv *ConfigValidator) validateLocalProxy(conf *Config) error {
+	def := DefaultLocalProxyConfig()
+	if conf.LocalProxy == nil {
+		conf.LocalProxy = def
+		return nil
+	}
+	proxy := conf.LocalProxy
+	if proxy.Type == "" {
+		proxy.Type = def.Type
+	}
+	if proxy.Listen == "" {
+		proxy.Listen = def.Listen
+		if proxy.AllowLAN {
+			proxy.Listen = "0.0.0.0"
+		}
+	}
+	if proxy.Port == 0 {
+		proxy.Port = def.Port
+	}
+	switch proxy.Type {
+	case "mixed", "http", "socks":
+	default:
+		return fmt.Errorf("invalid local proxy type: %s", proxy.Type)
+	}
+	addr, err := netip.ParseAddr(proxy.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid local proxy listen: %s", proxy.Listen)
+	}
+	if !addr.IsLoopback() && !proxy.AllowLAN {
+		return fmt.Errorf("local proxy listen %s requires allow_lan", proxy.Listen)
+	}
+	if (proxy.Username == "") != (proxy.Password == "") {
+		return errors.New("local proxy username and password must be set together")
+	}
+	return nil
+}
+
+// validateProcessSplit 补全进程分流默认值并校验
+func (cv *ConfigValidator) validateProcessSplit(conf *Config) error {
+	split := conf.ProcessSplit
+	if split == nil {
+		return nil
+	}
+	switch split.Mode {
+	case "":
+		split.Mode = ProcessSplitInclude
+	case ProcessSplitInclude, ProcessSplitExclude:
+	default:
+		return fmt.Errorf("invalid process split mode: %s", split.Mode)
+	}
+	if len(split.ProcessNames) == 0 && len(split.ProcessPaths) == 0 && len(split.UIDs) == 0 {
+		return errors.New("process split requires process names, paths or uids")
+	}
+	// 用户 ID 不支持时忽略，不影响加载配置
+	if len(split.UIDs) > 0 && split.SupportedUIDs() == nil {
+		if len(split.ProcessNames) == 0 && len(split.ProcessPaths) == 0 {
+			fmt.Println("Warning: process split uids are only supported on linux, process split disabled")
+		} else {
+			fmt.Println("Warning: process split uids are only supported on linux, ignored")
+		}
+	}
+	return nil
+}
+
+// validateDetours 前置节点必须存在且不能成环，直连节点不能使用前置节点
+func (cv *ConfigValidator) validateDetours(conf *Config) error {
+	for _, peer := range conf.PeerList {
+		if peer.Detour == "" {
+			continue
+		}
+		if peer.Protocol == "direct" {
+			return fmt.Errorf("direct peer cannot use detour: %s", peer.Name)
+		}
+		if _, err := DetourChain(peer, conf.PeerList); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateClientSubnets 节点客户端子网必须为 auto、地址或网段，直连节点不经代理 DNS 不能配置
+func (cv *ConfigValidator) validateClientSubnets(conf *Config) error {
+	for _, peer := range conf.PeerList {
+		if peer.ClientSubnet == "" {
+			continue
+		}
+		if peer.Protocol == "direct" {
+			return fmt.Errorf("direct peer cannot use client subnet: %s", peer.Name)
+		}
+		if peer.ClientSubnet == ClientSubnetAuto {
+			continue
+		}
+		if _, err := ParseClientSubnet(peer.ClientSubnet); err != nil {
+			return fmt.Errorf("peer %s: %w", peer.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateFailover 补全故障转移默认值，节点必须在节点列表中
+func (cv *ConfigValidator) validateFailover(conf *Config) error {
+	failover := conf.Failover
+	if failover == nil {
+		return nil
+	}
+	switch failover.Type {
+	case "":
+		failover.Type = FailoverURLTest
+	case FailoverURLTest, FailoverFallback:
+	default:
+		return fmt.Errorf("invalid failover type: %s", failover.Type)
+	}
+	if len(failover.Peers) == 0 {
+		return errors.New("failover requires at least one peer")
+	}
+	if err := cv.validatePeerNames("failover", failover.Peers, conf); err != nil {
+		return err
+	}
+	if err := cv.validateProbe("failover", &failover.URL, &failover.Interval); err != nil {
+		return err
+	}
+	if failover.Tolerance == 0 {
+		failover.Tolerance = 50
+	}
+	return nil
+}
+
+// validateLoadBalance 补全负载均衡默认值，节点必须在节点列表中
+func (cv *ConfigValidator) validateLoadBalance(conf *Config) error {
+	balance := conf.LoadBalance
+	if balance == nil {
+		return nil
+	}
+	switch balance.Strategy {
+	case "":
+		balance.Strategy = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceConsistentHash:
+	default:
+		return fmt.Errorf("invalid load balance strategy: %s", balance.Strategy)
+	}
+	if len(balance.Peers) == 0 {
+		return errors.New("load balance requires at least one peer")
+	}
+	if err := cv.validatePeerNames("load balance", balance.Peers, conf); err != nil {
+		return err
+	}
+	return cv.validateProbe("load balance", &balance.URL, &balance.Interval)
+}
+
+// validatePeerNames 检查节点名称均在节点列表中
+func (cv *ConfigValidator) validatePeerNames(kind string, names []string, conf *Config) error {
+	for _, name := range names {
+		found := false
+		for _, peer := range conf.PeerList {
+			if peer.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s peer not found: %s", kind, name)
+		}
+	}
+	return nil
+}
+
+// validateKillSwitch 补全断网保护的探测参数，默认探测间隔短于故障转移
+func (cv *ConfigValidator) validateKillSwitch(conf *Config) error {
+	killSwitch := conf.KillSwitch
+	if killSwitch == nil {
+		return nil
+	}
+	if killSwitch.Interval == 0 {
+		killSwitch.Interval = 10
+	}
+	return cv.validateProbe("kill switch", &killSwitch.URL, &killSwitch.Interval)
+}
+
+// validateProbe 补全并检查探测地址和间隔
+func (cv *ConfigValidator) validateProbe(kind string, probeURL *string, interval *uint32) error {
+	if *probeURL == "" {
+		*probeURL = DefaultProbeURL
+	}
+	u, err := url.Parse(*probeURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s url: %s", kind, *probeURL)
+	}
+	if *interval == 0 {
+		*interval = 60
+	}
+	// sing-box 要求探测间隔不超过空闲超时 30 分钟
+	if *interval < 5 || *interval > 1800 {
+		return fmt.Errorf("invalid %s interval: %d", kind, *interval)
+	}
+	return nil
+}
+
+// ValidateDNSAddresses 检查代理 DNS 和直连 DNS 地址，用于设置 DNS 和启动加速，加载配置时不检查
+func (cv *ConfigValidator) ValidateDNSAddresses(conf *Config) error {
+	if err := cv.validateProxyDNS(conf.ProxyDNS); err != nil {
+		return err
+	}
+	return cv.validateLocalDNS(conf.LocalDNS)
+}
+
+// validateProxyDNS 代理 DNS 经节点查询，必须为远程服务器
+func (cv *ConfigValidator) validateProxyDNS(address string) error {
+	proxyDNS, err := ParseDNSAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid proxy_dns: %w", err)
+	}
+	if !proxyDNS.IsRemote() {
+		return fmt.Errorf("invalid proxy_dns: %s server cannot use proxy", proxyDNS.Type)
+	}
+	return nil
+}
+
+// validateLocalDNS 直连 DNS 负责解析其他服务器的域名，远程服务器必须使用 IP
+func (cv *ConfigValidator) validateLocalDNS(address string) error {
+	localDNS, err := ParseDNSAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid local_dns: %w", err)
+	}
+	if _, err := netip.ParseAddr(localDNS.Host); localDNS.IsRemote() && err != nil {
+		return fmt.Errorf("invalid local_dns: server must be an IP address: %s", localDNS.Host)
+	}
+	return nil
+}
+
+// validateDNS 检查自定义 DNS 服务器 tag 唯一，规则引用的服务器存在，静态解析地址有效
+func (cv *ConfigValidator) validateDNS(conf *Config) error {
+	dnsConf := conf.DNS
+	if dnsConf == nil {
+		return nil
+	}
+	servers := map[string]bool{"proxyDns": true, "localDns": true, "block": true}
+	for i, server := range dnsConf.Servers {
+		if server.Tag == "" {
+			return fmt.Errorf("dns server %d missing tag", i)
+		}
+		if servers[server.Tag] || server.Tag == "fakeip" || server.Tag == "hosts" || server.Tag == "proxyDnsUpstream" {
+			return fmt.Errorf("duplicate dns server: %s", server.Tag)
+		}
+		servers[server.Tag] = true
+		if server.Address == "" {
+			return fmt.Errorf("dns server %s missing address", server.Tag)
+		}
+		switch server.Detour {
+		case "":
+			server.Detour = "proxy"
+		case "proxy", "direct":
+		default:
+			return fmt.Errorf("invalid dns server detour: %s", server.Detour)
+		}
+		address, err := ParseDNSAddress(server.Address)
+		if err != nil {
+			return fmt.Errorf("dns server %s: %w", server.Tag, err)
+		}
+		if server.Detour == "proxy" && !address.IsRemote() {
+			return fmt.Errorf("dns server %s: %s server cannot use proxy", server.Tag, address.Type)
+		}
+	}
+	if conf.FakeIP != nil {
+		servers["fakeip"] = true
+	}
+	for i, rule := range dnsConf.Rules {
+		if len(rule.Domains) == 0 && len(rule.RuleSets) == 0 {
+			return fmt.Errorf("dns rule %d requires domains or rule sets", i)
+		}
+		if !servers[rule.Server] {
+			return fmt.Errorf("dns rule %d: server not found: %s", i, rule.Server)
+		}
+		if rule.ClientSubnet != "" {
+			if _, err := ParseClientSubnet(rule.ClientSubnet); err != nil {
+				return fmt.Errorf("dns rule %d: %w", i, err)
+			}
+		}
+	}
+	for domain, addrs := range dnsConf.Hosts {
+		if strings.TrimSpace(domain) == "" || len(addrs) == 0 {
+			return fmt.Errorf("invalid hosts entry: %s", domain)
+		}
+		for _, addr := range addrs {
+			if _, err := netip.ParseAddr(addr); err != nil {
+				return fmt.Errorf("invalid hosts address for %s: %s", domain, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseClientSubnet 解析 EDNS 客户端子网，单个地址视为 /32 或 /128
+func ParseClientSubnet(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid client subnet: %s", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// validateFakeIP 补全 FakeIP 默认地址段，地址段必须与协议族匹配
+func (cv *ConfigValidator) validateFakeIP(conf *Config) error {
+	fakeIP := conf.FakeIP
+	if fakeIP == nil {
+		return nil
+	}
+	if fakeIP.Inet4Range == "" {
+		fakeIP.Inet4Range = "198.18.0.0/15"
+	}
+	if fakeIP.Inet6Range == "" {
+		fakeIP.Inet6Range = "fc00::/18"
+	}
+	prefix, err := netip.ParsePrefix(fakeIP.Inet4Range)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("invalid fakeip inet4 range: %s", fakeIP.Inet4Range)
+	}
+	prefix, err = netip.ParsePrefix(fakeIP.Inet6Range)
+	if err != nil || !prefix.Addr().Is6() {
+		return fmt.Errorf("invalid fakeip inet6 range: %s", fakeIP.Inet6Range)
+	}
+	for _, domain := range fakeIP.ExcludeDomains {
+		if strings.TrimSpace(domain) == "" {
+			return errors.New("fakeip exclude domain is empty")
+		}
+	}
+	return nil
+}
+
+// validateRuleSets 校验规则集 tag 唯一，且规则引用的规则集均已定义
+func (cv *ConfigValidator) validateRuleSets(conf *Config) error {
+	tags := make(map[string]bool)
+	for i, ruleSet := range conf.RuleSets {
+		if ruleSet.Tag == "" {
+			return fmt.Errorf("rule set %d missing tag", i)
+		}
+		if tags[ruleSet.Tag] {
+			return fmt.Errorf("duplicate rule set: %s", ruleSet.Tag)
+		}
+		tags[ruleSet.Tag] = true
+	}
+	for i, rule := range conf.Rules {
+		for _, tag := range ruleSetRefs(rule) {
+			if !tags[tag] {
+				return fmt.Errorf("rule %d: rule set not found: %s", i, tag)
+			}
+		}
+	}
+	if conf.DNS != nil {
+		for i, rule := range conf.DNS.Rules {
+			for _, tag := range rule.RuleSets {
+				if !tags[tag] {
+					return fmt.Errorf("dns rule %d: rule set not found: %s", i, tag)
+				}
+			}
+		}
+	}
+	if conf.FakeIP != nil {
+		for _, tag := range conf.FakeIP.ExcludeRuleSets {
+			if !tags[tag] {
+				return fmt.Errorf("fakeip: rule set not found: %s", tag)
+			}
+		}
+	}
+	return nil
+}
+
+// ruleSetRefs 返回规则（含逻辑规则的子规则）引用的规则集
+func ruleSetRefs(rule option.Rule) []string {
+	if rule.Type == C.RuleTypeLogical {
+		var refs []string
+		for _, sub := range rule.LogicalOptions.Rules {
+			refs = append(refs, ruleSetRefs(sub)...)
+		}
+		return refs
+	}
+	return rule.DefaultOptions.RuleSet
+}
+
+// DataPath 返回配置目录下的数据文件路径
+func DataPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(NewPathManager().GetPath()), name)
+}
+
 // ConfigLoader 配置加载器（组合所有功能）
 type ConfigLoader struct {
 	pathManager    *PathManager
@@ -254,8 +823,8 @@ func (cl *ConfigLoader) Init() error {
 					UUID:     "",
 				},
 			},
-			ProxyDNS: "https://1.1.1.1/dns-query",
-			LocalDNS: "https://223.5.5.5/dns-query",
+			ProxyDNS: DefaultProxyDNS,
+			LocalDNS: DefaultLocalDNS,
 		}
 		return cl.Save(defaultConfig)
 	}
@@ -97,6 +97,476 @@ func TestConfigValidator(t *testing.T) {
 	})
 }
 
+// TestValidateTun 测试 TUN 参数校验
+func TestValidateTun(t *testing.T) {
+	validator := NewConfigValidator()
+
+	t.Run("Defaults", func(t *testing.T) {
+		conf := &Config{Tun: &TunConfig{Stack: "gvisor"}}
+		if err := validator.Validate(conf); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		def := DefaultTunConfig()
+		if conf.Tun.InterfaceName != def.InterfaceName || conf.Tun.MTU != def.MTU || conf.Tun.Address != def.Address {
+			t.Errorf("defaults not applied: %+v", conf.Tun)
+		}
+		if conf.Tun.Stack != "gvisor" {
+			t.Errorf("stack overridden: %s", conf.Tun.Stack)
+		}
+		if conf.Tun.StrictRoute == nil || !*conf.Tun.StrictRoute {
+			t.Error("strict route should default to true")
+		}
+		// 已有配置的 IPv6 地址为空表示不分配 IPv6
+		if conf.Tun.Inet6Address != "" {
+			t.Errorf("inet6 address filled: %s", conf.Tun.Inet6Address)
+		}
+		conf = &Config{}
+		if err := validator.Validate(conf); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if conf.Tun.Inet6Address != def.Inet6Address {
+			t.Errorf("new tun config inet6 address = %s, want %s", conf.Tun.Inet6Address, def.Inet6Address)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			tun  *TunConfig
+		}{
+			{"Address", &TunConfig{Address: "172.25.0.1"}},
+			{"IPv6Address", &TunConfig{Address: "fd00::1/126"}},
+			{"Inet6Address", &TunConfig{Inet6Address: "10.0.0.1/30"}},
+			{"MTU", &TunConfig{MTU: 100}},
+			{"Stack", &TunConfig{Stack: "lwip"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := validator.Validate(&Config{Tun: tt.tun}); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
+
+// TestValidateDNSStrategy 测试解析策略校验
+func TestValidateDNSStrategy(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.DNSStrategy != "ipv4_only" {
+		t.Errorf("DNSStrategy = %s, want ipv4_only", conf.DNSStrategy)
+	}
+	if err := validator.Validate(&Config{DNSStrategy: "prefer_ipv6"}); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+	if err := validator.Validate(&Config{DNSStrategy: "ipv6_only"}); err == nil {
+		t.Error("expected error for unsupported strategy")
+	}
+}
+
+// TestValidateDNSAddresses 测试代理 DNS 和直连 DNS 地址校验
+func TestValidateDNSAddresses(t *testing.T) {
+	validator := NewConfigValidator()
+	if err := validator.Validate(&Config{ProxyDNS: "tls://dns.google", LocalDNS: "dhcp://auto"}); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	tests := []struct {
+		name string
+		conf *Config
+	}{
+		{"ProxyMalformed", &Config{ProxyDNS: "ftp://8.8.8.8", LocalDNS: DefaultLocalDNS}},
+		{"ProxyLocal", &Config{ProxyDNS: "local", LocalDNS: DefaultLocalDNS}},
+		{"LocalMalformed", &Config{ProxyDNS: DefaultProxyDNS, LocalDNS: "https://[::1"}},
+		{"LocalDomain", &Config{ProxyDNS: DefaultProxyDNS, LocalDNS: "tls://dns.alidns.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.ValidateDNSAddresses(tt.conf); err == nil {
+				t.Error("expected error")
+			}
+			// 已保存的配置仍可加载，地址保留原值
+			proxyDNS, localDNS := tt.conf.ProxyDNS, tt.conf.LocalDNS
+			if err := validator.Validate(tt.conf); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if tt.conf.ProxyDNS != proxyDNS || tt.conf.LocalDNS != localDNS {
+				t.Errorf("dns overwritten: %s %s", tt.conf.ProxyDNS, tt.conf.LocalDNS)
+			}
+		})
+	}
+}
+
+// TestValidateMode 测试路由模式校验
+func TestValidateMode(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.Mode != ModeRule {
+		t.Errorf("Mode = %s, want rule", conf.Mode)
+	}
+	if err := validator.Validate(&Config{Mode: "script"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+// TestValidateRateHistory 测试速率历史时长校验
+func TestValidateRateHistory(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.RateHistory != 5 {
+		t.Errorf("RateHistory = %d, want 5", conf.RateHistory)
+	}
+	if err := validator.Validate(&Config{RateHistory: 30}); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+	if err := validator.Validate(&Config{RateHistory: 61}); err == nil {
+		t.Error("expected error for rate history over 60 minutes")
+	}
+}
+
+// TestValidateLocalProxy 测试本地代理校验
+func TestValidateLocalProxy(t *testing.T) {
+	validator := NewConfigValidator()
+
+	t.Run("Defaults", func(t *testing.T) {
+		conf := &Config{}
+		if err := validator.Validate(conf); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if conf.LocalProxy.Listen != "127.0.0.1" || conf.LocalProxy.Port != 5123 || conf.LocalProxy.Type != "mixed" {
+			t.Errorf("defaults not applied: %+v", conf.LocalProxy)
+		}
+	})
+
+	t.Run("AllowLAN", func(t *testing.T) {
+		conf := &Config{LocalProxy: &LocalProxyConfig{AllowLAN: true}}
+		if err := validator.Validate(conf); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if conf.LocalProxy.Listen != "0.0.0.0" {
+			t.Errorf("Listen = %s, want 0.0.0.0", conf.LocalProxy.Listen)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			proxy *LocalProxyConfig
+		}{
+			{"Type", &LocalProxyConfig{Type: "tproxy"}},
+			{"Listen", &LocalProxyConfig{Listen: "localhost"}},
+			{"LANWithoutSwitch", &LocalProxyConfig{Listen: "192.168.1.2"}},
+			{"PasswordOnly", &LocalProxyConfig{Password: "secret"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := validator.Validate(&Config{LocalProxy: tt.proxy}); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
+
+// TestValidateClashAPI 测试 Clash 控制接口校验
+func TestValidateClashAPI(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{ClashAPI: &ClashAPIConfig{}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.ClashAPI.Listen != "127.0.0.1:9090" {
+		t.Errorf("Listen = %s, want 127.0.0.1:9090", conf.ClashAPI.Listen)
+	}
+	for _, api := range []*ClashAPIConfig{
+		{Listen: "0.0.0.0:9090", Secret: "secret"},
+		{Listen: "localhost:9090"},
+		{Listen: "[::1]:9090"},
+		{Listen: "router.lan:9090", Secret: "secret"},
+	} {
+		if err := validator.Validate(&Config{ClashAPI: api}); err != nil {
+			t.Errorf("Validate %+v failed: %v", api, err)
+		}
+	}
+	for _, api := range []*ClashAPIConfig{{Listen: "127.0.0.1"}, {Listen: "127.0.0.1:http"}, {Listen: "0.0.0.0:9090"}, {Listen: ":9090"}, {Listen: "router.lan:9090"}} {
+		if err := validator.Validate(&Config{ClashAPI: api}); err == nil {
+			t.Errorf("expected error for %+v", api)
+		}
+	}
+}
+
+// TestValidateProcessSplit 测试进程分流校验
+func TestValidateProcessSplit(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{ProcessSplit: &ProcessSplit{ProcessNames: []string{"cs2.exe"}}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.ProcessSplit.Mode != ProcessSplitInclude {
+		t.Errorf("Mode = %s, want include", conf.ProcessSplit.Mode)
+	}
+	if err := validator.Validate(&Config{ProcessSplit: &ProcessSplit{Mode: "include"}}); err == nil {
+		t.Error("expected error for empty process list")
+	}
+	if err := validator.Validate(&Config{ProcessSplit: &ProcessSplit{Mode: "only", ProcessNames: []string{"a"}}}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+
+	// 不支持用户 ID 的系统忽略该项，配置仍可加载并保留原值
+	supported := processUIDSupported
+	processUIDSupported = false
+	defer func() { processUIDSupported = supported }()
+	conf = &Config{ProcessSplit: &ProcessSplit{UIDs: []int32{1000}}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if len(conf.ProcessSplit.UIDs) != 1 || conf.ProcessSplit.SupportedUIDs() != nil {
+		t.Errorf("unexpected uids: %v, supported %v", conf.ProcessSplit.UIDs, conf.ProcessSplit.SupportedUIDs())
+	}
+}
+
+// TestValidateDetours 测试前置节点校验
+func TestValidateDetours(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{PeerList: []*Peer{{Name: "hk", Protocol: "vless", Detour: "jp"}, {Name: "jp", Protocol: "vless"}}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	conf.PeerList[1].Detour = "hk"
+	if err := validator.Validate(conf); err == nil {
+		t.Error("expected cycle error")
+	}
+	conf = &Config{PeerList: []*Peer{{Name: "直连", Protocol: "direct", Detour: "jp"}, {Name: "jp", Protocol: "vless"}}}
+	if err := validator.Validate(conf); err == nil {
+		t.Error("expected direct peer error")
+	}
+}
+
+// TestValidateClientSubnets 测试节点客户端子网校验
+func TestValidateClientSubnets(t *testing.T) {
+	validator := NewConfigValidator()
+	for _, subnet := range []string{ClientSubnetAuto, "203.0.113.0/24", "2001:db8::1"} {
+		conf := &Config{PeerList: []*Peer{{Name: "hk", Protocol: "vless", ClientSubnet: subnet}}}
+		if err := validator.Validate(conf); err != nil {
+			t.Errorf("Validate %s failed: %v", subnet, err)
+		}
+	}
+	for _, peer := range []*Peer{
+		{Name: "hk", Protocol: "vless", ClientSubnet: "hk"},
+		{Name: "直连", Protocol: "direct", ClientSubnet: ClientSubnetAuto},
+	} {
+		if err := validator.Validate(&Config{PeerList: []*Peer{peer}}); err == nil {
+			t.Errorf("expected error for %+v", peer)
+		}
+	}
+}
+
+// TestValidateFailover 测试故障转移校验
+func TestValidateFailover(t *testing.T) {
+	validator := NewConfigValidator()
+	peers := []*Peer{{Name: "hk", Protocol: "vless"}, {Name: "jp", Protocol: "vless"}}
+	conf := &Config{PeerList: peers, Failover: &Failover{Peers: []string{"hk", "jp"}}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.Failover.Type != FailoverURLTest || conf.Failover.URL == "" || conf.Failover.Interval != 60 {
+		t.Errorf("defaults not applied: %+v", conf.Failover)
+	}
+
+	tests := []struct {
+		name     string
+		failover *Failover
+	}{
+		{"Type", &Failover{Type: "load_balance", Peers: []string{"hk"}}},
+		{"NoPeers", &Failover{}},
+		{"UnknownPeer", &Failover{Peers: []string{"us"}}},
+		{"URL", &Failover{Peers: []string{"hk"}, URL: "ftp://a.com"}},
+		{"Interval", &Failover{Peers: []string{"hk"}, Interval: 3600}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.Validate(&Config{PeerList: peers, Failover: tt.failover}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestValidateLoadBalance 测试负载均衡参数校验
+func TestValidateLoadBalance(t *testing.T) {
+	validator := NewConfigValidator()
+	peers := []*Peer{{Name: "hk", Protocol: "vless"}, {Name: "jp", Protocol: "vless"}}
+	conf := &Config{PeerList: peers, LoadBalance: &LoadBalance{Peers: []string{"hk", "jp"}}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.LoadBalance.Strategy != BalanceRoundRobin || conf.LoadBalance.URL == "" || conf.LoadBalance.Interval != 60 {
+		t.Errorf("defaults not applied: %+v", conf.LoadBalance)
+	}
+
+	tests := []struct {
+		name    string
+		balance *LoadBalance
+	}{
+		{"Strategy", &LoadBalance{Strategy: "random", Peers: []string{"hk"}}},
+		{"NoPeers", &LoadBalance{}},
+		{"UnknownPeer", &LoadBalance{Peers: []string{"us"}}},
+		{"URL", &LoadBalance{Peers: []string{"hk"}, URL: "ftp://a.com"}},
+		{"Interval", &LoadBalance{Peers: []string{"hk"}, Interval: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.Validate(&Config{PeerList: peers, LoadBalance: tt.balance}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestValidateKillSwitch 测试断网保护参数校验
+func TestValidateKillSwitch(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{KillSwitch: &KillSwitch{}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.KillSwitch.URL != DefaultProbeURL || conf.KillSwitch.Interval != 10 {
+		t.Errorf("defaults not applied: %+v", conf.KillSwitch)
+	}
+	if err := validator.Validate(&Config{KillSwitch: &KillSwitch{URL: "ftp://a.com"}}); err == nil {
+		t.Error("expected url error")
+	}
+	if err := validator.Validate(&Config{KillSwitch: &KillSwitch{Interval: 1}}); err == nil {
+		t.Error("expected interval error")
+	}
+}
+
+// TestValidateDNS 测试自定义 DNS 校验
+func TestValidateDNS(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{DNS: &DNSConfig{
+		Servers: []*DNSServer{{Tag: "hkDns", Address: "8.8.8.8"}},
+		Rules:   []*DNSRule{{Domains: []string{"battle.net"}, Server: "hkDns", ClientSubnet: "203.0.113.1"}},
+		Hosts:   map[string][]string{"login.example.com": {"10.0.0.1", "::1"}},
+	}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.DNS.Servers[0].Detour != "proxy" {
+		t.Errorf("default detour not applied: %s", conf.DNS.Servers[0].Detour)
+	}
+
+	tests := []struct {
+		name string
+		dns  *DNSConfig
+	}{
+		{"DuplicateServer", &DNSConfig{Servers: []*DNSServer{{Tag: "localDns", Address: "8.8.8.8"}}}},
+		{"MissingAddress", &DNSConfig{Servers: []*DNSServer{{Tag: "hkDns"}}}},
+		{"Detour", &DNSConfig{Servers: []*DNSServer{{Tag: "hkDns", Address: "8.8.8.8", Detour: "hk"}}}},
+		{"ProxyLocal", &DNSConfig{Servers: []*DNSServer{{Tag: "hkDns", Address: "local"}}}},
+		{"Address", &DNSConfig{Servers: []*DNSServer{{Tag: "hkDns", Address: "rcode://success"}}}},
+		{"EmptyRule", &DNSConfig{Rules: []*DNSRule{{Server: "localDns"}}}},
+		{"UnknownServer", &DNSConfig{Rules: []*DNSRule{{Domains: []string{"a.com"}, Server: "hkDns"}}}},
+		{"ClientSubnet", &DNSConfig{Rules: []*DNSRule{{Domains: []string{"a.com"}, Server: "localDns", ClientSubnet: "a.b.c.d"}}}},
+		{"RuleSet", &DNSConfig{Rules: []*DNSRule{{RuleSets: []string{"geosite-cn"}, Server: "localDns"}}}},
+		{"HostsAddress", &DNSConfig{Hosts: map[string][]string{"a.com": {"a.com"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.Validate(&Config{DNS: tt.dns}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestValidateFakeIP 测试 FakeIP 参数校验
+func TestValidateFakeIP(t *testing.T) {
+	validator := NewConfigValidator()
+	conf := &Config{FakeIP: &FakeIPConfig{}}
+	if err := validator.Validate(conf); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if conf.FakeIP.Inet4Range != "198.18.0.0/15" || conf.FakeIP.Inet6Range != "fc00::/18" {
+		t.Errorf("defaults not applied: %+v", conf.FakeIP)
+	}
+
+	tests := []struct {
+		name   string
+		fakeIP *FakeIPConfig
+	}{
+		{"Inet4Range", &FakeIPConfig{Inet4Range: "fc00::/18"}},
+		{"Inet6Range", &FakeIPConfig{Inet6Range: "198.18.0.0"}},
+		{"ExcludeDomain", &FakeIPConfig{ExcludeDomains: []string{" "}}},
+		{"ExcludeRuleSet", &FakeIPConfig{ExcludeRuleSets: []string{"geosite-cn"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.Validate(&Config{FakeIP: tt.fakeIP}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestValidateRuleSets 测试规则集引用校验
+func TestValidateRuleSets(t *testing.T) {
+	validator := NewConfigValidator()
+	parse := func(t *testing.T, content string) *Config {
+		t.Helper()
+		conf := &Config{}
+		if err := json.Unmarshal([]byte(content), conf); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		return conf
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		conf := parse(t, `{
+			"rule_sets": [
+				{"tag": "geosite-cn", "type": "remote", "url": "https://example.com/geosite-cn.srs", "update_interval": "1d"},
+				{"tag": "games", "type": "local", "path": "games.json"}
+			],
+			"rules": [
+				{"rule_set": ["geosite-cn"], "outbound": "direct"},
+				{"type": "logical", "mode": "or", "rules": [{"rule_set": "games"}, {"port": 27015}], "outbound": "proxy"}
+			]
+		}`)
+		if err := validator.Validate(conf); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if conf.RuleSets[0].Format != "binary" || conf.RuleSets[1].Format != "source" {
+			t.Errorf("format not inferred: %s %s", conf.RuleSets[0].Format, conf.RuleSets[1].Format)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			content string
+		}{
+			{"Duplicate", `{"rule_sets": [{"tag": "a", "type": "local", "path": "a.json"}, {"tag": "a", "type": "local", "path": "b.json"}]}`},
+			{"MissingRef", `{"rules": [{"rule_set": "missing", "outbound": "direct"}]}`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := validator.Validate(parse(t, tt.content)); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
+
 // TestSubscriptionManager 测试订阅管理器
 func TestSubscriptionManager(t *testing.T) {
 	t.Run("UpdateFromSubscription", func(t *testing.T) {
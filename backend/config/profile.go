@@ -0,0 +1,237 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// QUICBlock 拦截游戏的 QUIC 流量，迫使其回落到 TCP
+	QUICBlock = "block"
+	// QUICAllow 放行游戏的 QUIC 流量
+	QUICAllow = "allow"
+)
+
+// GameProfile 游戏配置，描述一款游戏的流量特征及去向
+type GameProfile struct {
+	Name string `json:"name"`
+	// Domains 域名后缀
+	Domains []string `json:"domains,omitempty"`
+	CIDRs   []string `json:"cidrs,omitempty"`
+	// Ports 端口或端口范围，如 27015、27015:27050
+	Ports        []string `json:"ports,omitempty"`
+	ProcessNames []string `json:"process_names,omitempty"`
+	// QUIC 策略 block、allow，默认 block
+	QUIC string `json:"quic,omitempty"`
+	// Outbound 命中流量的出站 proxy、direct，默认 proxy
+	Outbound string `json:"outbound,omitempty"`
+}
+
+// Validate 补全默认值并校验游戏配置
+func (p *GameProfile) Validate() error {
+	if p.Name == "" {
+		return errors.New("profile missing name")
+	}
+	if strings.ContainsAny(p.Name, `/\`) {
+		return fmt.Errorf("invalid profile name: %s", p.Name)
+	}
+	if len(p.Domains) == 0 && len(p.CIDRs) == 0 && len(p.Ports) == 0 && len(p.ProcessNames) == 0 {
+		return fmt.Errorf("profile %s has no match conditions", p.Name)
+	}
+	for _, cidr := range p.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("profile %s: invalid cidr %s", p.Name, cidr)
+		}
+	}
+	for _, port := range p.Ports {
+		if _, _, err := ParsePortRange(port); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+	switch p.QUIC {
+	case "":
+		p.QUIC = QUICBlock
+	case QUICBlock, QUICAllow:
+	default:
+		return fmt.Errorf("profile %s: invalid quic policy %s", p.Name, p.QUIC)
+	}
+	switch p.Outbound {
+	case "":
+		p.Outbound = "proxy"
+	case "proxy", "direct":
+	default:
+		return fmt.Errorf("profile %s: invalid outbound %s", p.Name, p.Outbound)
+	}
+	return nil
+}
+
+// ParsePortRange 解析单个端口或 start:end 形式的端口范围
+func ParsePortRange(s string) (uint16, uint16, error) {
+	start, end, isRange := strings.Cut(s, ":")
+	from, err := strconv.ParseUint(start, 10, 16)
+	if err != nil || from == 0 {
+		return 0, 0, fmt.Errorf("invalid port %s", s)
+	}
+	if !isRange {
+		return uint16(from), uint16(from), nil
+	}
+	to, err := strconv.ParseUint(end, 10, 16)
+	if err != nil || to < from {
+		return 0, 0, fmt.Errorf("invalid port range %s", s)
+	}
+	return uint16(from), uint16(to), nil
+}
+
+// BuiltinProfiles 内置游戏配置，Steam 下载和国服服务器直连
+func BuiltinProfiles() []*GameProfile {
+	return []*GameProfile{
+		{
+			Name: "steam",
+			Domains: []string{
+				"vivox.com",
+				"cm.steampowered.com",
+				"steamchina.com",
+				"steamcontent.com",
+				"steamserver.net",
+				"steamusercontent.com",
+				"csgo.wmsj.cn",
+				"dl.steam.clngaa.com",
+				"dl.steam.ksyna.com",
+				"dota2.wmsj.cn",
+				"st.dl.bscstorage.net",
+				"st.dl.eccdnx.com",
+				"st.dl.pinyuncloud.com",
+				"steampipe.steamcontent.tnkjmec.com",
+				"steampowered.com.8686c.com",
+				"steamstatic.com.8686c.com",
+				"wmsjsteam.com",
+				"xz.pphimalayanrt.com",
+			},
+			CIDRs: []string{
+				"85.236.96.0/21",
+				"188.42.95.0/24",
+				"188.42.147.0/24",
+			},
+			QUIC:     QUICBlock,
+			Outbound: "direct",
+		},
+	}
+}
+
+// DefaultProfiles 未配置时启用的游戏配置
+func DefaultProfiles() []string {
+	return []string{"steam"}
+}
+
+// ProfileStore 管理配置目录下的游戏配置文件
+type ProfileStore struct {
+	dir string
+}
+
+// NewProfileStore 创建游戏配置存储，文件位于配置目录的 profiles 下
+func NewProfileStore() *ProfileStore {
+	return &ProfileStore{dir: DataPath("profiles")}
+}
+
+// List 返回内置及已导入的游戏配置，同名文件覆盖内置配置，无效的文件跳过并提示
+func (ps *ProfileStore) List() ([]*GameProfile, error) {
+	profiles := make(map[string]*GameProfile)
+	for _, profile := range BuiltinProfiles() {
+		profiles[profile.Name] = profile
+	}
+	entries, err := os.ReadDir(ps.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		profile, err := readProfile(filepath.Join(ps.dir, entry.Name()))
+		if err != nil {
+			fmt.Printf("Warning: %v, skipped\n", err)
+			continue
+		}
+		profiles[profile.Name] = profile
+	}
+	list := make([]*GameProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Load 按名称加载游戏配置，names 为 nil 时加载默认配置
+func (ps *ProfileStore) Load(names []string) ([]*GameProfile, error) {
+	if names == nil {
+		names = DefaultProfiles()
+	}
+	all, err := ps.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*GameProfile, 0, len(names))
+	for _, name := range names {
+		var found *GameProfile
+		for _, profile := range all {
+			if profile.Name == name {
+				found = profile
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("profile not found: %s", name)
+		}
+		result = append(result, found)
+	}
+	return result, nil
+}
+
+// Import 校验游戏配置文件并复制到配置目录
+func (ps *ProfileStore) Import(path string) (*GameProfile, error) {
+	profile, err := readProfile(path)
+	if err != nil {
+		return nil, err
+	}
+	return profile, ps.Save(profile)
+}
+
+// Save 保存游戏配置，文件名为配置名称
+func (ps *ProfileStore) Save(profile *GameProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ps.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ps.dir, profile.Name+".json"), data, 0o644)
+}
+
+func readProfile(path string) (*GameProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile := &GameProfile{}
+	if err = json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", filepath.Base(path), err)
+	}
+	if err = profile.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", filepath.Base(path), err)
+	}
+	return profile, nil
+}
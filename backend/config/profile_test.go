@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGameProfileValidate 测试游戏配置校验
+func TestGameProfileValidate(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		profile := &GameProfile{Name: "cs2", Ports: []string{"27015:27050"}}
+		if err := profile.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		if profile.QUIC != QUICBlock || profile.Outbound != "proxy" {
+			t.Errorf("defaults not applied: %+v", profile)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			profile *GameProfile
+		}{
+			{"MissingName", &GameProfile{Domains: []string{"a.com"}}},
+			{"PathName", &GameProfile{Name: "../a", Domains: []string{"a.com"}}},
+			{"NoConditions", &GameProfile{Name: "a"}},
+			{"CIDR", &GameProfile{Name: "a", CIDRs: []string{"1.1.1.1"}}},
+			{"PortRange", &GameProfile{Name: "a", Ports: []string{"200:100"}}},
+			{"QUIC", &GameProfile{Name: "a", Domains: []string{"a.com"}, QUIC: "proxy"}},
+			{"Outbound", &GameProfile{Name: "a", Domains: []string{"a.com"}, Outbound: "block"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := tt.profile.Validate(); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
+
+// TestProfileStore 测试游戏配置导入和加载
+func TestProfileStore(t *testing.T) {
+	ps := &ProfileStore{dir: filepath.Join(t.TempDir(), "profiles")}
+
+	profiles, err := ps.Load(nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(profiles) != 1 || profiles[0].Name != "steam" {
+		t.Errorf("expected builtin steam profile, got %v", profiles)
+	}
+
+	src := filepath.Join(t.TempDir(), "apex.json")
+	content := `{"name": "apex", "process_names": ["r5apex.exe"], "ports": ["37000:40000"], "quic": "allow"}`
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Import(src); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	list, err := ps.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "apex" {
+		t.Errorf("unexpected profiles: %v", list)
+	}
+
+	// 无效的文件跳过，其余配置仍可使用
+	if err := os.WriteFile(filepath.Join(ps.dir, "broken.json"), []byte(`{"name": "broken", "ports": ["x"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	list, err = ps.List()
+	if err != nil || len(list) != 2 {
+		t.Errorf("invalid file should be skipped: %v %v", list, err)
+	}
+
+	profiles, err = ps.Load([]string{"apex"})
+	if err != nil || len(profiles) != 1 || profiles[0].Outbound != "proxy" {
+		t.Errorf("Load apex failed: %v %v", profiles, err)
+	}
+	if _, err := ps.Load([]string{"missing"}); err == nil {
+		t.Error("expected error for missing profile")
+	}
+	if profiles, _ := ps.Load([]string{}); len(profiles) != 0 {
+		t.Error("empty selection should load no profiles")
+	}
+}
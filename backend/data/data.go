@@ -1,11 +1,115 @@
 package data
 
-import "github.com/danbai225/gpp/backend/config"
+import (
+	"time"
+
+	"github.com/danbai225/gpp/backend/config"
+)
 
 type Status struct {
 	Running  bool         `json:"running"`
 	GamePeer *config.Peer `json:"game_peer"`
 	HttpPeer *config.Peer `json:"http_peer"`
-	Up       uint64       `json:"up"`
-	Down     uint64       `json:"down"`
+	// Up、Down 本次加速经过路由的总流量
+	Up   uint64 `json:"up"`
+	Down uint64 `json:"down"`
+	// Traffic 按出站统计的流量
+	Traffic []OutboundTraffic `json:"traffic"`
+	// InboundMode 当前入站模式 tun、proxy
+	InboundMode string `json:"inbound_mode"`
+	// Mode 当前路由模式 rule、global、direct
+	Mode string `json:"mode"`
+	// ActivePeer 游戏流量实际使用的节点，启用故障转移时为节点组中选中的节点
+	ActivePeer string `json:"active_peer"`
+	// KillSwitch 断网保护正在拦截游戏流量
+	KillSwitch bool `json:"kill_switch"`
+}
+
+// OutboundTraffic 出站的上传、下载字节数
+type OutboundTraffic struct {
+	// Outbound 出站 game、http、direct、block、dns，或规则指定的其他出站 tag
+	Outbound string `json:"outbound"`
+	Up       uint64 `json:"up"`
+	Down     uint64 `json:"down"`
+}
+
+// OutboundRate 出站的上传、下载速率，单位字节每秒
+type OutboundRate struct {
+	Outbound string `json:"outbound"`
+	Up       uint64 `json:"up"`
+	Down     uint64 `json:"down"`
+}
+
+// RateSample 某一时刻各出站的速率
+type RateSample struct {
+	Time  time.Time      `json:"time"`
+	Rates []OutboundRate `json:"rates"`
+}
+
+// Rates 当前速率、本次加速的峰值和最近的速率历史
+type Rates struct {
+	Current []OutboundRate `json:"current"`
+	Peak    []OutboundRate `json:"peak"`
+	History []RateSample   `json:"history"`
+}
+
+// Connection 活动连接、命中的规则和流量
+type Connection struct {
+	ID          string `json:"id"`
+	Network     string `json:"network"`
+	Inbound     string `json:"inbound"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Domain      string `json:"domain"`
+	// IP 目标地址，按域名连接时为解析结果，未解析时为空
+	IP      string `json:"ip"`
+	Process string `json:"process"`
+	Rule    string `json:"rule"`
+	// Outbound 出站 game、http、direct、block、dns，或规则指定的其他出站 tag
+	Outbound string    `json:"outbound"`
+	Up       uint64    `json:"up"`
+	Down     uint64    `json:"down"`
+	Start    time.Time `json:"start"`
+}
+
+// BalanceMember 负载均衡节点的健康状态和流量
+type BalanceMember struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Up      uint64 `json:"up"`
+	Down    uint64 `json:"down"`
+}
+
+// DNS 查询的应答来源
+const (
+	DNSSourceCache  = "cache"
+	DNSSourceRule   = "rule"
+	DNSSourceServer = "server"
+)
+
+// DNSQuery DNS 查询记录
+type DNSQuery struct {
+	Time   time.Time `json:"time"`
+	Domain string    `json:"domain"`
+	Type   string    `json:"type"`
+	// Source 应答来源：缓存、拒绝或预定义规则、DNS 服务器（含 hosts 和 FakeIP）
+	Source string `json:"source"`
+	// Server 实际查询的服务器，命中缓存或规则直接应答时为空
+	Server string   `json:"server"`
+	Answer []string `json:"answer"`
+	// Latency 耗时毫秒数
+	Latency uint `json:"latency"`
+	// Direct 查询未经过代理
+	Direct bool `json:"direct"`
+	// Leak 经直连服务器查询，且不是 DNS 规则有意发往该服务器
+	Leak  bool   `json:"leak"`
+	Error string `json:"error"`
+}
+
+// DNSLeak 加速期间未按规则经直连服务器查询的域名
+type DNSLeak struct {
+	Domain string    `json:"domain"`
+	Server string    `json:"server"`
+	Count  int       `json:"count"`
+	Last   time.Time `json:"last"`
 }
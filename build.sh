@@ -1,2 +1,2 @@
 go install github.com/wailsapp/wails/v2/cmd/wails@latest
-wails build -m -trimpath -tags webkit2_41,with_quic
\ No newline at end of file
+wails build -m -trimpath -tags webkit2_41,with_quic,with_clash_api,with_dhcp
\ No newline at end of file
@@ -39,6 +39,9 @@ func main() {
 		config.UUID = uuid.New().String()
 	}
 	err = core.Server(config)
+	if err == nil && config.Subscription != nil {
+		err = core.Subscribe(config)
+	}
 	if err != nil {
 		fmt.Println("run err:", err)
 	} else {
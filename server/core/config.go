@@ -1,8 +1,100 @@
 package core
 
 type Peer struct {
+	Protocol string  `json:"protocol"`
+	Port     uint16  `json:"port"`
+	Addr     string  `json:"addr"`
+	UUID     string  `json:"uuid"`
+	Egress   *Egress `json:"egress,omitempty"`
+	DNS      *DNS    `json:"dns,omitempty"`
+	// Subscription 可选，对外提供订阅地址
+	Subscription *Subscription `json:"subscription,omitempty"`
+}
+
+// Egress 出口配置，按目标域名或网段选择流量离开服务器的出口
+type Egress struct {
+	Outbounds []EgressOutbound `json:"outbounds"`
+	Rules     []EgressRule     `json:"rules"`
+	// Final 未命中规则时使用的出口，默认直连
+	Final string `json:"final"`
+}
+
+// EgressOutbound 出口定义，支持 direct、socks、http、wireguard
+type EgressOutbound struct {
+	Tag        string `json:"tag"`
+	Type       string `json:"type"`
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	// BindInterface 绑定本地网卡
+	BindInterface string `json:"bind_interface"`
+	// BindAddress 绑定本地IP
+	BindAddress string `json:"bind_address"`
+	// WireGuard 专用
+	PrivateKey   string   `json:"private_key"`
+	PublicKey    string   `json:"public_key"`
+	PreSharedKey string   `json:"pre_shared_key"`
+	Address      []string `json:"address"`
+	MTU          uint32   `json:"mtu"`
+}
+
+// EgressRule 出口规则，任一条件命中即使用对应出口
+type EgressRule struct {
+	Domain       []string `json:"domain"`
+	DomainSuffix []string `json:"domain_suffix"`
+	IPCIDR       []string `json:"ip_cidr"`
+	Outbound     string   `json:"outbound"`
+}
+
+// DNS 服务端解析配置，替代系统默认解析器
+type DNS struct {
+	// Servers 上游列表，第一个为默认上游，为空时使用系统解析器
+	Servers []DNSServer `json:"servers"`
+	// Strategy 解析策略 prefer_ipv4、prefer_ipv6、ipv4_only、ipv6_only
+	Strategy      string `json:"strategy"`
+	DisableCache  bool   `json:"disable_cache"`
+	CacheCapacity uint32 `json:"cache_capacity"`
+	// ClientSubnet 附带的 EDNS 客户端子网，用于让上游返回指定地区的结果
+	ClientSubnet string `json:"client_subnet"`
+}
+
+// DNSServer 上游地址，支持 8.8.8.8、tcp://、tls://、https://、local
+type DNSServer struct {
+	Address string `json:"address"`
+	// Detour 通过指定出口访问上游
+	Detour string `json:"detour"`
+}
+
+// Subscription 订阅服务配置，按用户下发可用的入站列表
+type Subscription struct {
+	// Listen 订阅服务监听地址，如 0.0.0.0:34556
+	Listen string `json:"listen"`
+	// TLSCert、TLSKey 证书和私钥文件路径，均填写时以 HTTPS 提供订阅
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Name 本机入站在订阅中的名称，默认 地址:端口，有用户限制节点时必填
+	Name string `json:"name"`
+	// Addr 客户端连接本机入站的地址，默认使用请求的 Host
+	Addr string `json:"addr"`
+	// Peers 其他服务器的入站，随订阅一起下发
+	Peers []SubscriptionPeer `json:"peers"`
+	Users []SubscriptionUser `json:"users"`
+}
+
+// SubscriptionPeer 订阅中的节点，格式与客户端节点一致
+type SubscriptionPeer struct {
+	Name     string `json:"name"`
 	Protocol string `json:"protocol"`
 	Port     uint16 `json:"port"`
 	Addr     string `json:"addr"`
 	UUID     string `json:"uuid"`
 }
+
+// SubscriptionUser 订阅用户，通过 /sub/<token> 获取节点
+type SubscriptionUser struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Peers 允许使用的节点名称，为空表示全部
+	Peers []string `json:"peers"`
+}
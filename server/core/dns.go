@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+// dnsResolverTag 解析域名形式上游地址的系统解析器
+const dnsResolverTag = "dns-resolver"
+
+// buildDNS 根据解析配置生成 DNS 选项和出站默认解析器
+func buildDNS(dns *DNS, outbounds []option.Outbound, endpoints []option.Endpoint) (*option.DNSOptions, *option.DomainResolveOptions, error) {
+	if dns == nil {
+		return nil, nil, nil
+	}
+	servers := dns.Servers
+	if len(servers) == 0 {
+		if dns.Strategy == "" && !dns.DisableCache && dns.CacheCapacity == 0 && dns.ClientSubnet == "" {
+			return nil, nil, nil
+		}
+		// 未配置上游时解析策略、缓存和客户端子网作用于系统解析器
+		servers = []DNSServer{{Address: "local"}}
+	}
+	strategy, err := parseStrategy(dns.Strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	detours := make(map[string]bool)
+	for _, out := range outbounds {
+		detours[out.Tag] = true
+	}
+	for _, ep := range endpoints {
+		detours[ep.Tag] = true
+	}
+	options := &option.DNSOptions{
+		RawDNSOptions: option.RawDNSOptions{
+			DNSClientOptions: option.DNSClientOptions{
+				Strategy:      strategy,
+				DisableCache:  dns.DisableCache,
+				CacheCapacity: dns.CacheCapacity,
+			},
+		},
+	}
+	if dns.ClientSubnet != "" {
+		subnet, err := parseSubnet(dns.ClientSubnet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid dns client_subnet %s: %w", dns.ClientSubnet, err)
+		}
+		options.ClientSubnet = &subnet
+	}
+	var needResolver bool
+	for i, server := range servers {
+		if server.Detour != "" && !detours[server.Detour] {
+			return nil, nil, fmt.Errorf("dns server %d: detour not found: %s", i, server.Detour)
+		}
+		serverOptions, resolve, err := parseDNSServer(fmt.Sprintf("dns-%d", i), server)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dns server %d: %w", i, err)
+		}
+		needResolver = needResolver || resolve
+		options.Servers = append(options.Servers, serverOptions)
+	}
+	options.Final = options.Servers[0].Tag
+	if needResolver {
+		// 域名形式的上游（如 DoH、DoT）先经系统解析器解析自身地址
+		options.Servers = append(options.Servers, option.DNSServerOptions{
+			Type:    C.DNSTypeLocal,
+			Tag:     dnsResolverTag,
+			Options: &option.LocalDNSServerOptions{},
+		})
+	}
+	resolver := &option.DomainResolveOptions{
+		Server:   options.Final,
+		Strategy: strategy,
+	}
+	return options, resolver, nil
+}
+
+// parseDNSServer 将地址解析为对应类型的 DNS 服务器，上游为域名时 resolve 为 true，由 dnsResolverTag 解析
+func parseDNSServer(tag string, server DNSServer) (option.DNSServerOptions, bool, error) {
+	local := option.LocalDNSServerOptions{
+		DialerOptions: option.DialerOptions{
+			Detour: server.Detour,
+		},
+	}
+	if server.Address == "local" {
+		return option.DNSServerOptions{
+			Type:    C.DNSTypeLocal,
+			Tag:     tag,
+			Options: &local,
+		}, false, nil
+	}
+	address := server.Address
+	if !strings.Contains(address, "://") {
+		address = "udp://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return option.DNSServerOptions{}, false, fmt.Errorf("invalid address %s: %w", server.Address, err)
+	}
+	if u.Hostname() == "" {
+		return option.DNSServerOptions{}, false, fmt.Errorf("missing host in address %s", server.Address)
+	}
+	_, err = netip.ParseAddr(u.Hostname())
+	resolve := err != nil
+	if resolve {
+		local.DomainResolver = &option.DomainResolveOptions{Server: dnsResolverTag}
+	}
+	remote := option.RemoteDNSServerOptions{
+		LocalDNSServerOptions: local,
+		DNSServerAddressOptions: option.DNSServerAddressOptions{
+			Server: u.Hostname(),
+		},
+	}
+	if u.Port() != "" {
+		port, err := strconv.ParseUint(u.Port(), 10, 16)
+		if err != nil {
+			return option.DNSServerOptions{}, false, fmt.Errorf("invalid port in address %s", server.Address)
+		}
+		remote.ServerPort = uint16(port)
+	}
+	switch u.Scheme {
+	case C.DNSTypeUDP, C.DNSTypeTCP:
+		return option.DNSServerOptions{
+			Type:    u.Scheme,
+			Tag:     tag,
+			Options: &remote,
+		}, resolve, nil
+	case C.DNSTypeTLS:
+		return option.DNSServerOptions{
+			Type: C.DNSTypeTLS,
+			Tag:  tag,
+			Options: &option.RemoteTLSDNSServerOptions{
+				RemoteDNSServerOptions: remote,
+			},
+		}, resolve, nil
+	case C.DNSTypeHTTPS:
+		return option.DNSServerOptions{
+			Type: C.DNSTypeHTTPS,
+			Tag:  tag,
+			Options: &option.RemoteHTTPSDNSServerOptions{
+				RemoteTLSDNSServerOptions: option.RemoteTLSDNSServerOptions{
+					RemoteDNSServerOptions: remote,
+				},
+				Path: u.Path,
+			},
+		}, resolve, nil
+	default:
+		return option.DNSServerOptions{}, false, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+}
+
+func parseStrategy(strategy string) (option.DomainStrategy, error) {
+	switch strategy {
+	case "":
+		return option.DomainStrategy(C.DomainStrategyAsIS), nil
+	case "prefer_ipv4":
+		return option.DomainStrategy(C.DomainStrategyPreferIPv4), nil
+	case "prefer_ipv6":
+		return option.DomainStrategy(C.DomainStrategyPreferIPv6), nil
+	case "ipv4_only":
+		return option.DomainStrategy(C.DomainStrategyIPv4Only), nil
+	case "ipv6_only":
+		return option.DomainStrategy(C.DomainStrategyIPv6Only), nil
+	default:
+		return 0, fmt.Errorf("unknown dns strategy: %s", strategy)
+	}
+}
+
+func parseSubnet(s string) (badoption.Prefixable, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err == nil {
+		return badoption.Prefixable(prefix), nil
+	}
+	addr, addrErr := netip.ParseAddr(s)
+	if addrErr != nil {
+		return badoption.Prefixable{}, err
+	}
+	return badoption.Prefixable(netip.PrefixFrom(addr, addr.BitLen())), nil
+}
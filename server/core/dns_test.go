@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	box "github.com/sagernet/sing-box"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+)
+
+func TestBuildDNS(t *testing.T) {
+	outbounds, endpoints, _, err := buildEgress(&Egress{
+		Outbounds: []EgressOutbound{{Tag: "up", Type: "socks", Server: "1.2.3.4", ServerPort: 1080}},
+	})
+	if err != nil {
+		t.Fatalf("buildEgress failed: %v", err)
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		dns, resolver, err := buildDNS(nil, outbounds, endpoints)
+		if err != nil || dns != nil || resolver != nil {
+			t.Errorf("expected nothing, got %v %v %v", dns, resolver, err)
+		}
+	})
+
+	t.Run("Servers", func(t *testing.T) {
+		dns, resolver, err := buildDNS(&DNS{
+			Servers: []DNSServer{
+				{Address: "https://1.1.1.1/dns-query"},
+				{Address: "tls://8.8.8.8:853", Detour: "up"},
+				{Address: "9.9.9.9"},
+				{Address: "local"},
+			},
+			Strategy:      "ipv4_only",
+			CacheCapacity: 2048,
+			ClientSubnet:  "1.2.3.0/24",
+		}, outbounds, endpoints)
+		if err != nil {
+			t.Fatalf("buildDNS failed: %v", err)
+		}
+		types := []string{C.DNSTypeHTTPS, C.DNSTypeTLS, C.DNSTypeUDP, C.DNSTypeLocal}
+		for i, server := range dns.Servers {
+			if server.Type != types[i] {
+				t.Errorf("server %d type = %s, want %s", i, server.Type, types[i])
+			}
+		}
+		tls := dns.Servers[1].Options.(*option.RemoteTLSDNSServerOptions)
+		if tls.Server != "8.8.8.8" || tls.ServerPort != 853 || tls.Detour != "up" {
+			t.Errorf("unexpected tls options: %+v", tls)
+		}
+		if dns.Final != dns.Servers[0].Tag || resolver.Server != dns.Final {
+			t.Error("default server should be the first upstream")
+		}
+		if resolver.Strategy != option.DomainStrategy(C.DomainStrategyIPv4Only) {
+			t.Errorf("unexpected strategy: %v", resolver.Strategy)
+		}
+		if dns.ClientSubnet == nil {
+			t.Error("client subnet not applied")
+		}
+	})
+
+	t.Run("SystemResolver", func(t *testing.T) {
+		dns, resolver, err := buildDNS(&DNS{Strategy: "ipv4_only", CacheCapacity: 2048, ClientSubnet: "1.2.3.0/24"}, outbounds, endpoints)
+		if err != nil {
+			t.Fatalf("buildDNS failed: %v", err)
+		}
+		if len(dns.Servers) != 1 || dns.Servers[0].Type != C.DNSTypeLocal || dns.Final != dns.Servers[0].Tag {
+			t.Fatalf("expected a single local server, got %+v", dns.Servers)
+		}
+		if dns.CacheCapacity != 2048 || dns.ClientSubnet == nil || resolver.Strategy != option.DomainStrategy(C.DomainStrategyIPv4Only) {
+			t.Errorf("options not applied: %+v %+v", dns.DNSClientOptions, resolver)
+		}
+		if dns, _, err := buildDNS(&DNS{}, outbounds, endpoints); err != nil || dns != nil {
+			t.Errorf("empty dns config should use the default resolver, got %v %v", dns, err)
+		}
+		if _, _, err := buildDNS(&DNS{Strategy: "ipv5"}, outbounds, endpoints); err == nil {
+			t.Error("expected error for unknown strategy")
+		}
+	})
+
+	t.Run("Hostname", func(t *testing.T) {
+		dns, resolver, err := buildDNS(&DNS{
+			Servers: []DNSServer{
+				{Address: "https://dns.google/dns-query"},
+				{Address: "tls://dns.quad9.net", Detour: "up"},
+				{Address: "1.1.1.1"},
+			},
+		}, outbounds, endpoints)
+		if err != nil {
+			t.Fatalf("buildDNS failed: %v", err)
+		}
+		last := dns.Servers[len(dns.Servers)-1]
+		if len(dns.Servers) != 4 || last.Tag != dnsResolverTag || last.Type != C.DNSTypeLocal {
+			t.Fatalf("hostname upstreams need a local resolver, got %+v", dns.Servers)
+		}
+		https := dns.Servers[0].Options.(*option.RemoteHTTPSDNSServerOptions)
+		if https.DomainResolver == nil || https.DomainResolver.Server != dnsResolverTag {
+			t.Errorf("unexpected https options: %+v", https)
+		}
+		if udp := dns.Servers[2].Options.(*option.RemoteDNSServerOptions); udp.DomainResolver != nil {
+			t.Error("ip upstream should not use a domain resolver")
+		}
+		instance, err := box.New(box.Options{
+			Context: include.Context(context.Background()),
+			Options: option.Options{
+				Log:       &option.LogOptions{Disabled: true},
+				DNS:       dns,
+				Outbounds: outbounds,
+				Route:     &option.RouteOptions{DefaultDomainResolver: resolver},
+			},
+		})
+		if err != nil {
+			t.Fatalf("box.New failed: %v", err)
+		}
+		_ = instance.Close()
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			dns  *DNS
+		}{
+			{"UnknownScheme", &DNS{Servers: []DNSServer{{Address: "quic://1.1.1.1"}}}},
+			{"MissingHost", &DNS{Servers: []DNSServer{{Address: "https:///dns-query"}}}},
+			{"UnknownDetour", &DNS{Servers: []DNSServer{{Address: "1.1.1.1", Detour: "missing"}}}},
+			{"UnknownStrategy", &DNS{Servers: []DNSServer{{Address: "1.1.1.1"}}, Strategy: "ipv5"}},
+			{"InvalidSubnet", &DNS{Servers: []DNSServer{{Address: "1.1.1.1"}}, ClientSubnet: "abc"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, _, err := buildDNS(tt.dns, outbounds, endpoints); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"net/netip"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+const directOut = "direct-out"
+
+// buildEgress 根据出口配置生成 outbounds、endpoints 和路由
+func buildEgress(egress *Egress) ([]option.Outbound, []option.Endpoint, *option.RouteOptions, error) {
+	outbounds := []option.Outbound{
+		{
+			Type: "direct",
+			Tag:  directOut,
+		},
+	}
+	if egress == nil {
+		return outbounds, nil, nil, nil
+	}
+	var endpoints []option.Endpoint
+	tags := map[string]bool{directOut: true}
+	for _, out := range egress.Outbounds {
+		if out.Tag == "" {
+			return nil, nil, nil, fmt.Errorf("egress outbound missing tag")
+		}
+		if tags[out.Tag] {
+			return nil, nil, nil, fmt.Errorf("duplicate egress outbound tag: %s", out.Tag)
+		}
+		tags[out.Tag] = true
+		dialer, err := egressDialer(out)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch out.Type {
+		case "direct":
+			outbounds = append(outbounds, option.Outbound{
+				Type: "direct",
+				Tag:  out.Tag,
+				Options: &option.DirectOutboundOptions{
+					DialerOptions: dialer,
+				},
+			})
+		case "socks":
+			outbounds = append(outbounds, option.Outbound{
+				Type: "socks",
+				Tag:  out.Tag,
+				Options: &option.SOCKSOutboundOptions{
+					DialerOptions: dialer,
+					ServerOptions: option.ServerOptions{
+						Server:     out.Server,
+						ServerPort: out.ServerPort,
+					},
+					Username: out.Username,
+					Password: out.Password,
+				},
+			})
+		case "http":
+			outbounds = append(outbounds, option.Outbound{
+				Type: "http",
+				Tag:  out.Tag,
+				Options: &option.HTTPOutboundOptions{
+					DialerOptions: dialer,
+					ServerOptions: option.ServerOptions{
+						Server:     out.Server,
+						ServerPort: out.ServerPort,
+					},
+					Username: out.Username,
+					Password: out.Password,
+				},
+			})
+		case "wireguard":
+			address := make(badoption.Listable[netip.Prefix], 0, len(out.Address))
+			for _, s := range out.Address {
+				prefix, err := netip.ParsePrefix(s)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("egress %s: invalid address %s: %w", out.Tag, s, err)
+				}
+				address = append(address, prefix)
+			}
+			if len(address) == 0 || out.PrivateKey == "" || out.PublicKey == "" {
+				return nil, nil, nil, fmt.Errorf("egress %s: wireguard requires address, private_key and public_key", out.Tag)
+			}
+			endpoints = append(endpoints, option.Endpoint{
+				Type: "wireguard",
+				Tag:  out.Tag,
+				Options: &option.WireGuardEndpointOptions{
+					MTU:        out.MTU,
+					Address:    address,
+					PrivateKey: out.PrivateKey,
+					Peers: []option.WireGuardPeer{
+						{
+							Address:      out.Server,
+							Port:         out.ServerPort,
+							PublicKey:    out.PublicKey,
+							PreSharedKey: out.PreSharedKey,
+							AllowedIPs: badoption.Listable[netip.Prefix]{
+								netip.MustParsePrefix("0.0.0.0/0"),
+								netip.MustParsePrefix("::/0"),
+							},
+						},
+					},
+					DialerOptions: dialer,
+				},
+			})
+		default:
+			return nil, nil, nil, fmt.Errorf("egress %s: unsupported type: %s", out.Tag, out.Type)
+		}
+	}
+
+	route := &option.RouteOptions{
+		Final: directOut,
+	}
+	if len(egress.Rules) > 0 {
+		// 嗅探 SNI/Host，使域名规则对按 IP 连接的流量同样生效
+		route.Rules = append(route.Rules, option.Rule{
+			Type: C.RuleTypeDefault,
+			DefaultOptions: option.DefaultRule{
+				RuleAction: option.RuleAction{
+					Action: C.RuleActionTypeSniff,
+				},
+			},
+		})
+	}
+	if egress.Final != "" {
+		if !tags[egress.Final] {
+			return nil, nil, nil, fmt.Errorf("egress final outbound not found: %s", egress.Final)
+		}
+		route.Final = egress.Final
+	}
+	for i, rule := range egress.Rules {
+		if !tags[rule.Outbound] {
+			return nil, nil, nil, fmt.Errorf("egress rule %d: outbound not found: %s", i, rule.Outbound)
+		}
+		if len(rule.Domain) == 0 && len(rule.DomainSuffix) == 0 && len(rule.IPCIDR) == 0 {
+			return nil, nil, nil, fmt.Errorf("egress rule %d: missing conditions", i)
+		}
+		for _, cidr := range rule.IPCIDR {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				return nil, nil, nil, fmt.Errorf("egress rule %d: invalid ip_cidr %s: %w", i, cidr, err)
+			}
+		}
+		route.Rules = append(route.Rules, option.Rule{
+			Type: "default",
+			DefaultOptions: option.DefaultRule{
+				RawDefaultRule: option.RawDefaultRule{
+					Domain:       rule.Domain,
+					DomainSuffix: rule.DomainSuffix,
+					IPCIDR:       rule.IPCIDR,
+				},
+				RuleAction: option.RuleAction{
+					Action: C.RuleActionTypeRoute,
+					RouteOptions: option.RouteActionOptions{
+						Outbound: rule.Outbound,
+					},
+				},
+			},
+		})
+	}
+	return outbounds, endpoints, route, nil
+}
+
+// egressDialer 生成出口的本地绑定参数
+func egressDialer(out EgressOutbound) (option.DialerOptions, error) {
+	dialer := option.DialerOptions{
+		BindInterface: out.BindInterface,
+	}
+	if out.BindAddress != "" {
+		addr, err := netip.ParseAddr(out.BindAddress)
+		if err != nil {
+			return dialer, fmt.Errorf("egress %s: invalid bind_address %s: %w", out.Tag, out.BindAddress, err)
+		}
+		bind := badoption.Addr(addr)
+		if addr.Is4() {
+			dialer.Inet4BindAddress = &bind
+		} else {
+			dialer.Inet6BindAddress = &bind
+		}
+	}
+	return dialer, nil
+}
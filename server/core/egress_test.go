@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/sagernet/sing-box/option"
+)
+
+func TestBuildEgress(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		outbounds, endpoints, route, err := buildEgress(nil)
+		if err != nil {
+			t.Fatalf("buildEgress failed: %v", err)
+		}
+		if len(outbounds) != 1 || outbounds[0].Tag != directOut {
+			t.Errorf("expected only %s, got %v", directOut, outbounds)
+		}
+		if endpoints != nil || route != nil {
+			t.Error("expected no endpoints and no route")
+		}
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		egress := &Egress{
+			Outbounds: []EgressOutbound{
+				{Tag: "wg", Type: "wireguard", Server: "1.2.3.4", ServerPort: 51820, PrivateKey: "a", PublicKey: "b", Address: []string{"10.0.0.2/32"}},
+				{Tag: "up", Type: "socks", Server: "5.6.7.8", ServerPort: 1080},
+				{Tag: "eth1", Type: "direct", BindInterface: "eth1", BindAddress: "192.168.1.2"},
+			},
+			Rules: []EgressRule{
+				{DomainSuffix: []string{"example.com"}, Outbound: "wg"},
+				{IPCIDR: []string{"1.1.1.0/24"}, Outbound: "up"},
+			},
+			Final: "eth1",
+		}
+		outbounds, endpoints, route, err := buildEgress(egress)
+		if err != nil {
+			t.Fatalf("buildEgress failed: %v", err)
+		}
+		if len(outbounds) != 3 {
+			t.Errorf("expected 3 outbounds, got %d", len(outbounds))
+		}
+		if len(endpoints) != 1 || endpoints[0].Tag != "wg" {
+			t.Errorf("expected wireguard endpoint, got %v", endpoints)
+		}
+		if route.Final != "eth1" {
+			t.Errorf("final = %s, want eth1", route.Final)
+		}
+		// 嗅探规则 + 两条出口规则
+		if len(route.Rules) != 3 {
+			t.Errorf("expected 3 rules, got %d", len(route.Rules))
+		}
+		direct := outbounds[2].Options.(*option.DirectOutboundOptions)
+		if direct.BindInterface != "eth1" || direct.Inet4BindAddress == nil {
+			t.Error("bind options not applied")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			egress *Egress
+		}{
+			{"DuplicateTag", &Egress{Outbounds: []EgressOutbound{{Tag: "a", Type: "direct"}, {Tag: "a", Type: "direct"}}}},
+			{"UnknownType", &Egress{Outbounds: []EgressOutbound{{Tag: "a", Type: "vmess"}}}},
+			{"UnknownOutbound", &Egress{Rules: []EgressRule{{Domain: []string{"a.com"}, Outbound: "missing"}}}},
+			{"MissingConditions", &Egress{Rules: []EgressRule{{Outbound: directOut}}}},
+			{"InvalidCIDR", &Egress{Rules: []EgressRule{{IPCIDR: []string{"1.1.1.1"}, Outbound: directOut}}}},
+			{"InvalidBind", &Egress{Outbounds: []EgressOutbound{{Tag: "a", Type: "direct", BindAddress: "eth0"}}}},
+			{"UnknownFinal", &Egress{Final: "missing"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, _, _, err := buildEgress(tt.egress); err == nil {
+					t.Error("expected error")
+				}
+			})
+		}
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/include"
 	"github.com/sagernet/sing-box/option"
 	"github.com/sagernet/sing/common/auth"
 	"github.com/sagernet/sing/common/json/badoption"
@@ -106,8 +107,22 @@ func Server(conf Peer) error {
 			},
 		}
 	}
-	var instance, err = box.New(box.Options{
-		Context: context.Background(),
+	outbounds, endpoints, route, err := buildEgress(conf.Egress)
+	if err != nil {
+		return err
+	}
+	dns, resolver, err := buildDNS(conf.DNS, outbounds, endpoints)
+	if err != nil {
+		return err
+	}
+	if resolver != nil {
+		if route == nil {
+			route = &option.RouteOptions{}
+		}
+		route.DefaultDomainResolver = resolver
+	}
+	instance, err := box.New(box.Options{
+		Context: include.Context(context.Background()),
 		Options: option.Options{
 			Log: &option.LogOptions{
 				Disabled:     false,
@@ -116,13 +131,11 @@ func Server(conf Peer) error {
 				Timestamp:    true,
 				DisableColor: true,
 			},
-			Inbounds: []option.Inbound{in},
-			Outbounds: []option.Outbound{
-				{
-					Type: "direct",
-					Tag:  "direct-out",
-				},
-			},
+			DNS:       dns,
+			Inbounds:  []option.Inbound{in},
+			Outbounds: outbounds,
+			Endpoints: endpoints,
+			Route:     route,
 		},
 	})
 	if err != nil {
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const subscriptionPath = "/sub/"
+
+// Subscribe 启动订阅服务，监听失败时返回错误
+// 订阅内容包含用户 token 和入站 UUID，未配置证书时只应监听回环地址或置于 TLS 反向代理之后
+func Subscribe(conf Peer) error {
+	handler, err := SubscriptionHandler(conf)
+	if err != nil {
+		return err
+	}
+	sub := conf.Subscription
+	var certificate tls.Certificate
+	if sub.TLSCert != "" {
+		certificate, err = tls.LoadX509KeyPair(sub.TLSCert, sub.TLSKey)
+		if err != nil {
+			return fmt.Errorf("load subscription certificate failed: %w", err)
+		}
+	}
+	listener, err := net.Listen("tcp", sub.Listen)
+	if err != nil {
+		return fmt.Errorf("subscription listen failed: %w", err)
+	}
+	if sub.TLSCert != "" {
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	} else if addr, ok := listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		fmt.Println("Warning: subscription is served over plain http, tokens and uuids can be sniffed; set tls_cert and tls_key or put it behind a TLS proxy")
+	}
+	go func() {
+		err := http.Serve(listener, handler)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("subscription serve err:", err)
+		}
+	}()
+	return nil
+}
+
+// SubscriptionHandler 校验订阅配置并返回处理 /sub/<token> 的 handler
+func SubscriptionHandler(conf Peer) (http.Handler, error) {
+	sub := conf.Subscription
+	if sub == nil || sub.Listen == "" {
+		return nil, errors.New("subscription listen address is required")
+	}
+	if (sub.TLSCert == "") != (sub.TLSKey == "") {
+		return nil, errors.New("subscription tls_cert and tls_key must be set together")
+	}
+	local := SubscriptionPeer{
+		Name:     sub.Name,
+		Protocol: conf.Protocol,
+		Port:     conf.Port,
+		Addr:     sub.Addr,
+		UUID:     conf.UUID,
+	}
+	if local.Protocol == "" {
+		local.Protocol = "vless"
+	}
+	peers := append([]SubscriptionPeer{local}, sub.Peers...)
+	names := make(map[string]bool)
+	for i, peer := range peers {
+		// 本机名称可能依赖请求的 Host，留到请求时生成
+		if i == 0 && peer.Name == "" {
+			continue
+		}
+		if peer.Name == "" {
+			return nil, fmt.Errorf("subscription peer %d missing name", i)
+		}
+		if names[peer.Name] {
+			return nil, fmt.Errorf("duplicate subscription peer: %s", peer.Name)
+		}
+		names[peer.Name] = true
+	}
+	tokens := make(map[string]bool)
+	for _, user := range sub.Users {
+		if user.Token == "" {
+			return nil, fmt.Errorf("subscription user %s missing token", user.Name)
+		}
+		if tokens[user.Token] {
+			return nil, fmt.Errorf("duplicate subscription token for user %s", user.Name)
+		}
+		tokens[user.Token] = true
+		// 本机未命名时请求前无法确定名称，无法被用户列出或排除
+		if len(user.Peers) > 0 && sub.Name == "" {
+			return nil, fmt.Errorf("subscription user %s restricts peers, subscription name is required", user.Name)
+		}
+		for _, name := range user.Peers {
+			if !names[name] {
+				return nil, fmt.Errorf("subscription user %s: peer not found: %s", user.Name, name)
+			}
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(subscriptionPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := findUser(sub.Users, strings.TrimPrefix(r.URL.Path, subscriptionPath))
+		if user == nil {
+			http.NotFound(w, r)
+			return
+		}
+		list := make([]SubscriptionPeer, 0, len(peers))
+		for i, peer := range peers {
+			if i == 0 {
+				peer = localPeer(peer, r)
+			}
+			if len(user.Peers) > 0 && !contains(user.Peers, peer.Name) {
+				continue
+			}
+			list = append(list, peer)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(list)
+	})
+	return mux, nil
+}
+
+// localPeer 补全本机入站的地址和名称
+func localPeer(peer SubscriptionPeer, r *http.Request) SubscriptionPeer {
+	if peer.Addr == "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		peer.Addr = host
+	}
+	if peer.Name == "" {
+		peer.Name = net.JoinHostPort(peer.Addr, fmt.Sprint(peer.Port))
+	}
+	return peer
+}
+
+// findUser 以常量时间比较 token，避免时序攻击
+func findUser(users []SubscriptionUser, token string) *SubscriptionUser {
+	var found *SubscriptionUser
+	for i := range users {
+		if subtle.ConstantTimeCompare([]byte(users[i].Token), []byte(token)) == 1 {
+			found = &users[i]
+		}
+	}
+	return found
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubscriptionHandler(t *testing.T) {
+	conf := Peer{
+		Protocol: "vless",
+		Port:     34555,
+		UUID:     "local-uuid",
+		Subscription: &Subscription{
+			Listen: "127.0.0.1:0",
+			Name:   "local",
+			Peers: []SubscriptionPeer{
+				{Name: "hk", Protocol: "shadowsocks", Port: 5123, Addr: "hk.example.com", UUID: "hk-uuid"},
+				{Name: "jp", Protocol: "vless", Port: 5123, Addr: "jp.example.com", UUID: "jp-uuid"},
+			},
+			Users: []SubscriptionUser{
+				{Name: "all", Token: "token-all"},
+				{Name: "hk-only", Token: "token-hk", Peers: []string{"hk"}},
+				{Name: "local-only", Token: "token-local", Peers: []string{"local"}},
+			},
+		},
+	}
+	handler, err := SubscriptionHandler(conf)
+	if err != nil {
+		t.Fatalf("SubscriptionHandler failed: %v", err)
+	}
+	fetchFrom := func(handler http.Handler, path string) (int, []SubscriptionPeer) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "relay.example.com:34556"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var peers []SubscriptionPeer
+		_ = json.Unmarshal(rec.Body.Bytes(), &peers)
+		return rec.Code, peers
+	}
+	fetch := func(path string) (int, []SubscriptionPeer) {
+		return fetchFrom(handler, path)
+	}
+
+	t.Run("AllPeers", func(t *testing.T) {
+		code, peers := fetch("/sub/token-all")
+		if code != http.StatusOK {
+			t.Fatalf("status = %d", code)
+		}
+		if len(peers) != 3 {
+			t.Fatalf("expected 3 peers, got %d", len(peers))
+		}
+		local := peers[0]
+		if local.Addr != "relay.example.com" || local.Name != "local" || local.UUID != "local-uuid" {
+			t.Errorf("unexpected local peer: %+v", local)
+		}
+	})
+
+	t.Run("DefaultName", func(t *testing.T) {
+		sub := *conf.Subscription
+		sub.Name = ""
+		sub.Users = []SubscriptionUser{{Name: "all", Token: "token-all"}}
+		unnamed := conf
+		unnamed.Subscription = &sub
+		handler, err := SubscriptionHandler(unnamed)
+		if err != nil {
+			t.Fatalf("SubscriptionHandler failed: %v", err)
+		}
+		_, peers := fetchFrom(handler, "/sub/token-all")
+		if len(peers) != 3 || peers[0].Name != "relay.example.com:34555" {
+			t.Errorf("unexpected peers: %+v", peers)
+		}
+	})
+
+	t.Run("RestrictedPeers", func(t *testing.T) {
+		_, peers := fetch("/sub/token-hk")
+		if len(peers) != 1 || peers[0].Name != "hk" {
+			t.Errorf("expected only hk, got %+v", peers)
+		}
+		_, peers = fetch("/sub/token-local")
+		if len(peers) != 1 || peers[0].Name != "local" {
+			t.Errorf("expected only local, got %+v", peers)
+		}
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		if code, _ := fetch("/sub/wrong"); code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", code)
+		}
+		if code, _ := fetch("/sub/"); code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", code)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		bad := conf
+		sub := *conf.Subscription
+		sub.Users = []SubscriptionUser{{Name: "x", Token: "t", Peers: []string{"missing"}}}
+		bad.Subscription = &sub
+		if _, err := SubscriptionHandler(bad); err == nil {
+			t.Error("expected error for unknown peer")
+		}
+		sub.Users = []SubscriptionUser{{Name: "x"}}
+		if _, err := SubscriptionHandler(bad); err == nil {
+			t.Error("expected error for missing token")
+		}
+		// 本机未命名时用户不能限制节点
+		sub.Name = ""
+		sub.Users = []SubscriptionUser{{Name: "x", Token: "t", Peers: []string{"hk"}}}
+		if _, err := SubscriptionHandler(bad); err == nil {
+			t.Error("expected error for restricted user without name")
+		}
+		sub.Name = "local"
+		sub.TLSCert = "cert.pem"
+		if _, err := SubscriptionHandler(bad); err == nil {
+			t.Error("expected error for tls_cert without tls_key")
+		}
+	})
+}